@@ -1,17 +1,26 @@
 package main
 
 import (
+	"context"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
 	"chain-traverser/api/handlers"
+	"chain-traverser/internal/config"
+	"chain-traverser/internal/storage/redis"
 
 	"github.com/fasthttp/router"
 	"github.com/valyala/fasthttp"
 )
 
+// how often the API checks whether the migrator switched the keyspace version
+const VERSION_REFRESH_INTERVAL = 10 * time.Second
+
 func pingHandler(c *fasthttp.RequestCtx) {
 	c.SetBodyString("pong")
 }
@@ -21,13 +30,54 @@ func main() {
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 	zerolog.SetGlobalLevel(zerolog.InfoLevel)
 
+	cfg, err := config.NewConfig()
+	if err != nil {
+		log.Err(err).Msg("error reading config")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	redis, err := redis.NewClient(ctx, &cfg.Redis)
+	if err != nil {
+		log.Err(err).Msg("error connecting to redis")
+		return
+	}
+	defer redis.Close()
+	go redis.WatchVersion(ctx, VERSION_REFRESH_INTERVAL)
+
+	h := handlers.NewHandler(cfg, redis)
 	r := router.New()
 
 	r.GET("/ping/", pingHandler)
-	r.GET("/orb/eth/{address}", handlers.CollectGraphHandler)
-	r.GET("/orb/eth/paths/{addressFrom}/to/{addressTo}", handlers.CollectPathHandler)
+	r.POST("/orb/eth", h.CollectSeedsHandler)
+	r.OPTIONS("/orb/eth", handlers.CorsPreflight)
+	r.POST("/orb/eth/expand", h.ExpandHandler)
+	r.OPTIONS("/orb/eth/expand", handlers.CorsPreflight)
+	r.GET("/orb/eth/{address}", h.CollectGraphHandler)
+	r.GET("/orb/eth/paths/{addressFrom}/to/{addressTo}", h.CollectPathHandler)
+	r.GET("/orb/eth/taint/{address}", h.TaintHandler)
+	r.GET("/orb/eth/cluster/{id}", h.ClusterHandler)
+	r.GET("/orb/eth/cycles/{address}", h.CyclesHandler)
+	r.GET("/orb/eth/peel/{address}", h.PeelChainHandler)
+	r.GET("/orb/eth/patterns/{address}", h.PatternsHandler)
+
+	server := &fasthttp.Server{Handler: r.Handler}
+
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+		log.Info().Msg("Interrupt received, shutting down...")
+		if err := server.Shutdown(); err != nil {
+			log.Err(err).Msg("error shutting down server")
+		}
+	}()
 
 	log.Info().Msg("Fasthttp server is starting...")
 
-	fasthttp.ListenAndServe(":9000", r.Handler)
+	if err := server.ListenAndServe(":9000"); err != nil {
+		log.Err(err).Msg("server stopped")
+	}
 }
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/valyala/fasthttp"
+
+	"chain-traverser/api/handlers/schemas"
+	"chain-traverser/internal/analysis"
+	"chain-traverser/internal/traverser"
+)
+
+// checkAnalysis validates the optional analysis of a graph request, empty disables it
+func checkAnalysis(weight string) error {
+	if weight != "" && weight != analysis.WEIGHT_USD && weight != analysis.WEIGHT_COUNT {
+		return errors.New("Invalid analysis parameter")
+	}
+	return nil
+}
+
+func analysisParam(c *fasthttp.RequestCtx) (string, error) {
+	weight := string(c.QueryArgs().Peek("analysis"))
+	return weight, checkAnalysis(weight)
+}
+
+// analyzeGraph scores the nodes of the graph, nil when no analysis is asked for
+func analyzeGraph(graph *traverser.Graph, weight string) (*analysis.Scores, *schemas.GraphAnalysis, error) {
+	if weight == "" {
+		return nil, nil, nil
+	}
+	scores, err := analysis.Analyze(graph, weight)
+	if err != nil {
+		return nil, nil, err
+	}
+	summary := &schemas.GraphAnalysis{Weight: weight, Modularity: scores.Modularity}
+	for _, component := range scores.Weak {
+		summary.Components = max(summary.Components, component+1)
+	}
+	for _, community := range scores.Community {
+		summary.Communities = max(summary.Communities, community+1)
+	}
+	return scores, summary, nil
+}
+
+func nodeScores(scores *analysis.Scores, hash string) *schemas.NodeScores {
+	if scores == nil {
+		return nil
+	}
+	if _, ok := scores.PageRank[hash]; !ok {
+		return nil
+	}
+	return &schemas.NodeScores{
+		PageRank:        scores.PageRank[hash],
+		Betweenness:     scores.Betweenness[hash],
+		Component:       scores.Weak[hash],
+		StrongComponent: scores.Strong[hash],
+		Community:       scores.Community[hash],
+	}
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+
+	"chain-traverser/api/handlers/schemas"
+	"chain-traverser/api/handlers/utils"
+	"chain-traverser/internal/storage"
+	"chain-traverser/internal/traverser"
+)
+
+// clusterParams parses the optional leaf clustering of a graph request
+func clusterParams(c *fasthttp.RequestCtx) (string, int, error) {
+	by := string(c.QueryArgs().Peek("cluster"))
+	var minSize int
+	if minSizeStr := string(c.QueryArgs().Peek("clusterMinSize")); minSizeStr != "" {
+		var err error
+		minSize, err = strconv.Atoi(minSizeStr)
+		if err != nil || minSize < 0 {
+			return "", 0, errors.New("Invalid clusterMinSize parameter")
+		}
+	}
+	return by, minSize, nil
+}
+
+// clusterLeaves groups leaves of the graph when by is set and keeps the members
+// of every cluster for the cluster endpoint
+func (h *Handler) clusterLeaves(ctx context.Context, graph *traverser.Graph, query traverser.Query, by string, minSize int) error {
+	if by == "" {
+		return nil
+	}
+	if err := traverser.ClusterLeaves(ctx, graph, query, by, minSize, h.redis); err != nil {
+		return err
+	}
+	if len(graph.Clusters) == 0 {
+		return nil
+	}
+	members := make(map[string][]string, len(graph.Clusters))
+	for id, cluster := range graph.Clusters {
+		members[id] = cluster.Members
+	}
+	return h.redis.SaveClusters(ctx, members, h.cfg.Api.ClusterTTL)
+}
+
+func clusterNode(cluster *traverser.Cluster) schemas.Node {
+	return schemas.Node{
+		Id:    cluster.Id,
+		Label: fmt.Sprintf("%d %s", len(cluster.Members), cluster.Key),
+		Cnt:   int64(cluster.Txs),
+		Type:  "cluster",
+		Cluster: &schemas.ClusterSummary{
+			Anchor:         cluster.Anchor,
+			Direction:      cluster.Direction,
+			Key:            cluster.Key,
+			Members:        len(cluster.Members),
+			Txs:            cluster.Txs,
+			FlowByCurrency: cluster.FlowByCurrency,
+			TotalUsdFlow:   cluster.TotalUsdFlow,
+		},
+	}
+}
+
+// ClusterHandler returns the members of a cluster node of a recent graph response
+func (h *Handler) ClusterHandler(c *fasthttp.RequestCtx) {
+	start := time.Now()
+
+	id, ok := c.UserValue("id").(string)
+	if !ok || !traverser.IsClusterId(id) {
+		c.Error("Invalid cluster id", fasthttp.StatusBadRequest)
+		return
+	}
+	members, err := h.redis.GetCluster(c, id)
+	if errors.Is(err, storage.ErrNotFound) {
+		c.Error("Cluster not found or expired", fasthttp.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Err(err).Msgf("GetCluster %s failed", id)
+		c.Error("Error fetching cluster", errorStatus(err))
+		return
+	}
+
+	nodes, err := utils.FetchAddresses(c, members, h.redis)
+	if err != nil {
+		c.Error("Error fetching address", errorStatus(err))
+		return
+	}
+	jsonData, err := json.Marshal(schemas.ClusterMembers{Id: id, Nodes: nodes})
+	if err != nil {
+		c.Error("Error encoding JSON", fasthttp.StatusInternalServerError)
+		return
+	}
+	c.Write(jsonData)
+
+	c.SetContentType("application/json")
+	c.Response.Header.Set("Access-Control-Allow-Origin", "*")
+	c.Response.Header.Set("Access-Control-Allow-Methods", "GET")
+	c.Response.Header.Set("Access-Control-Allow-Headers", "Content-Type")
+	c.Response.SetStatusCode(fasthttp.StatusOK)
+
+	log.Info().Msgf("ClusterHandler %s in %s", id, time.Since(start))
+}
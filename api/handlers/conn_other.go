@@ -0,0 +1,11 @@
+//go:build !unix
+
+package handlers
+
+import (
+	"context"
+	"net"
+)
+
+// watchConn does nothing where sockets can't be peeked, requests only end on their deadline
+func watchConn(ctx context.Context, conn net.Conn, cancel context.CancelFunc) {}
@@ -0,0 +1,55 @@
+//go:build unix
+
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// how often a running request checks that its client is still connected
+const CONN_CHECK_INTERVAL = 500 * time.Millisecond
+
+// watchConn cancels a request once its client closes the connection. The socket is
+// peeked without blocking, so a pipelined request stays unread for the server, and
+// hides a disconnect behind it.
+// Connections without a socket to peek, like TLS ones, are not watched.
+func watchConn(ctx context.Context, conn net.Conn, cancel context.CancelFunc) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return
+	}
+	ticker := time.NewTicker(CONN_CHECK_INTERVAL)
+	defer ticker.Stop()
+	buf := make([]byte, 1)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		closed := false
+		err := raw.Control(func(fd uintptr) {
+			n, _, err := syscall.Recvfrom(int(fd), buf, syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+			// zero bytes without an error is the end of the stream
+			closed = (n == 0 && err == nil) || errors.Is(err, syscall.ECONNRESET)
+		})
+		if err != nil {
+			return
+		}
+		if closed {
+			log.Info().Msgf("client %s disconnected, cancelling the request", conn.RemoteAddr())
+			cancel()
+			return
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+
+	"chain-traverser/api/handlers/schemas"
+	"chain-traverser/api/handlers/utils"
+	"chain-traverser/internal/analysis"
+	"chain-traverser/internal/traverser"
+)
+
+// CyclesHandler reports the round trips bringing funds sent by an address back to it,
+// every hop happening after the previous one
+func (h *Handler) CyclesHandler(c *fasthttp.RequestCtx) {
+	start := time.Now()
+
+	root, ok := c.UserValue("address").(string)
+	if !ok || root == "" {
+		c.Error("Bad Request", fasthttp.StatusBadRequest)
+		return
+	}
+	ints := map[string]int{"maxLength": analysis.DEFAULT_CYCLE_LENGTH, "limit": analysis.DEFAULT_CYCLE_LIMIT, "fromBlock": 0, "toBlock": traverser.MAX_BLOCK}
+	for name := range ints {
+		str := string(c.QueryArgs().Peek(name))
+		if str == "" {
+			continue
+		}
+		value, err := strconv.Atoi(str)
+		if err != nil || value < 0 {
+			c.Error("Invalid "+name+" parameter", fasthttp.StatusBadRequest)
+			return
+		}
+		ints[name] = value
+	}
+	maxDegree, neverExpand, alwaysExpand, err := hubParams(c)
+	if err != nil {
+		c.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	edges, err := edgeParams(c)
+	if err != nil {
+		c.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel, err := h.requestContext(c, string(c.QueryArgs().Peek("timeout")))
+	if err != nil {
+		c.Error("Invalid timeout parameter", fasthttp.StatusBadRequest)
+		return
+	}
+	defer cancel()
+
+	fromBlock, toBlock, err := h.blockRange(ctx, ints["fromBlock"], ints["toBlock"], string(c.QueryArgs().Peek("fromTime")), string(c.QueryArgs().Peek("toTime")))
+	if err != nil {
+		writeRangeError(c, err)
+		return
+	}
+
+	query := traverser.Query{
+		Roots:          []string{root},
+		FromBlock:      fromBlock,
+		ToBlock:        toBlock,
+		GraphSizeLimit: h.cfg.Api.GraphSizeLimit,
+		MaxDegree:      maxDegree,
+		NeverExpand:    neverExpand,
+		AlwaysExpand:   alwaysExpand,
+		Edges:          edges,
+		Fetch:          h.fetchOptions(),
+	}
+	opts := analysis.CycleOptions{MaxLength: ints["maxLength"], Limit: ints["limit"]}
+	cycles, graph, err := analysis.RoundTrips(ctx, query, opts, h.redis)
+	if errors.Is(err, traverser.ErrInvalidQuery) {
+		c.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Err(err).Msgf("cycle search of %s failed", root)
+		c.Error("Error searching cycles", errorStatus(err))
+		return
+	}
+	h.logCacheStats()
+
+	blocks := []int{}
+	addrs := []string{}
+	seen := make(map[string]bool)
+	for _, cycle := range cycles {
+		for _, tx := range cycle.Txs {
+			blocks = append(blocks, tx.Block)
+		}
+		for _, addr := range cycle.Route {
+			if !seen[addr] {
+				seen[addr] = true
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	nodes, err := utils.FetchAddresses(c, addrs, h.redis)
+	if err != nil {
+		c.Error("Error fetching address", errorStatus(err))
+		return
+	}
+	for i := range nodes {
+		nodes[i].Picked = nodes[i].Id == root
+	}
+
+	data := schemas.CyclesResponse{
+		Root:      root,
+		MaxLength: opts.MaxLength,
+		Nodes:     nodes,
+		Cycles:    schemas.NewCycles(cycles, h.blockTimes(c, blocks)),
+		Truncated: graph.Truncated,
+	}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		c.Error("Error encoding JSON", fasthttp.StatusInternalServerError)
+		return
+	}
+	c.Write(jsonData)
+
+	c.SetContentType("application/json")
+	c.Response.Header.Set("Access-Control-Allow-Origin", "*")
+	c.Response.Header.Set("Access-Control-Allow-Methods", "GET")
+	c.Response.Header.Set("Access-Control-Allow-Headers", "Content-Type")
+	c.Response.SetStatusCode(fasthttp.StatusOK)
+
+	log.Info().Msgf("CyclesHandler %s found %d cycles in %s", root, len(cycles), time.Since(start))
+}
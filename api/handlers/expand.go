@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	"chain-traverser/api/handlers/schemas"
+)
+
+const EXPAND_DEFAULT_DEPTH = 1
+
+// ExpandHandler grows a graph the client already holds: the expand addresses are
+// traversed with the usual options, visited ones are not expanded again, and only
+// new nodes and the edges reaching them are returned
+func (h *Handler) ExpandHandler(c *fasthttp.RequestCtx) {
+	start := time.Now()
+
+	var req schemas.ExpandRequest
+	if err := json.Unmarshal(c.PostBody(), &req); err != nil {
+		c.Error("Invalid JSON body", fasthttp.StatusBadRequest)
+		return
+	}
+	if len(req.Expand) == 0 {
+		c.Error("expand required", fasthttp.StatusBadRequest)
+		return
+	}
+	if len(req.Expand) > h.cfg.Api.MaxSeeds {
+		c.Error(fmt.Sprintf("too many addresses to expand, max %d", h.cfg.Api.MaxSeeds), fasthttp.StatusBadRequest)
+		return
+	}
+	if len(req.Visited) > h.cfg.Api.GraphSizeLimit {
+		c.Error(fmt.Sprintf("too many visited addresses, max %d", h.cfg.Api.GraphSizeLimit), fasthttp.StatusBadRequest)
+		return
+	}
+	if req.Depth == 0 {
+		req.Depth = EXPAND_DEFAULT_DEPTH
+	}
+	req.Seeds = req.Expand
+
+	visited := make(map[string]bool, len(req.Visited)+len(req.Expand))
+	for _, addr := range req.Visited {
+		visited[addr] = true
+	}
+	// expanded addresses are in the graph of the client too
+	for _, addr := range req.Expand {
+		visited[addr] = true
+	}
+	h.seedsGraph(c, req.SeedsRequest, visited, start)
+}
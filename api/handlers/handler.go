@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"chain-traverser/internal/config"
+	"chain-traverser/internal/storage/redis"
+	"chain-traverser/internal/traverser"
+
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+)
+
+// Handler serves API requests with dependencies shared by the whole process
+type Handler struct {
+	cfg   *config.Config
+	redis *redis.RedisClient
+	// parsed blocks shared by all requests, nil if disabled
+	blockCache *traverser.BlockCache
+}
+
+func NewHandler(cfg *config.Config, redis *redis.RedisClient) *Handler {
+	return &Handler{
+		cfg:        cfg,
+		redis:      redis,
+		blockCache: traverser.NewBlockCache(int64(cfg.Traverser.SharedCacheMB) << 20),
+	}
+}
+
+func (h *Handler) fetchOptions() traverser.FetchOptions {
+	opts := traverser.NewFetchOptions(&h.cfg.Traverser)
+	opts.Shared = h.blockCache
+	return opts
+}
+
+// collect runs the query with the traverser implementing algo
+func (h *Handler) collect(ctx context.Context, algo string, query traverser.Query) (*traverser.Graph, error) {
+	t, err := traverser.New(algo, h.redis)
+	if err != nil {
+		return nil, err
+	}
+	return t.Collect(ctx, query)
+}
+
+// logCacheStats reports the shared block cache, if any, after a traversal
+func (h *Handler) logCacheStats() {
+	if h.blockCache != nil {
+		log.Info().Msgf("shared block cache: %s", h.blockCache.Stats())
+	}
+}
+
+// requestContext derives the traversal context of a request. The budget is
+// API_REQUEST_TIMEOUT, a request may ask for less with a timeout like "10s".
+// The context is also cancelled when the client disconnects.
+func (h *Handler) requestContext(c *fasthttp.RequestCtx, timeoutStr string) (context.Context, context.CancelFunc, error) {
+	budget := h.cfg.Api.RequestTimeout
+	if timeoutStr != "" {
+		timeout, err := time.ParseDuration(timeoutStr)
+		if err != nil || timeout <= 0 {
+			return nil, nil, errors.New("timeout invalid")
+		}
+		budget = min(budget, timeout)
+	}
+	ctx, cancel := context.WithTimeout(c, budget)
+	go watchConn(ctx, c.Conn(), cancel)
+	return ctx, cancel, nil
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"strconv"
@@ -12,11 +13,9 @@ import (
 
 	"chain-traverser/api/handlers/schemas"
 	"chain-traverser/api/handlers/utils"
-	"chain-traverser/internal/config"
+	"chain-traverser/internal/paths"
 	"chain-traverser/internal/storage/redis"
 	"chain-traverser/internal/traverser"
-
-	dominik "github.com/dominikbraun/graph"
 )
 
 type Params struct {
@@ -24,8 +23,31 @@ type Params struct {
 	ToHash    string
 	FromBlock int
 	ToBlock   int
+	// RFC 3339 or unix seconds, resolved to blocks by blockRange
+	FromTime string
+	ToTime   string
+	// temporal search options
+	MaxHops  int
+	MaxGap   int
+	MaxPaths int
+	// SEARCH_TEMPORAL or SEARCH_SHORTEST
+	Search string
+	// shortest search options
+	K    int
+	Rank string
+	// which addresses are expanded, see hubParams
+	MaxDegree    int
+	NeverExpand  []string
+	AlwaysExpand []string
+	// transactions hops may use
+	Edges traverser.EdgeFilter
 }
 
+const (
+	SEARCH_TEMPORAL = "temporal"
+	SEARCH_SHORTEST = "shortest"
+)
+
 func extractParams(c *fasthttp.RequestCtx) (Params, error) {
 	var params Params
 
@@ -58,7 +80,7 @@ func extractParams(c *fasthttp.RequestCtx) (Params, error) {
 		params.FromBlock = fromBlock
 	}
 
-	toBlockStr := string(c.QueryArgs().Peek("toBlock invalid"))
+	toBlockStr := string(c.QueryArgs().Peek("toBlock"))
 	if toBlockStr != "" {
 		ToBlock, err := strconv.Atoi(toBlockStr)
 		if err != nil {
@@ -67,50 +89,85 @@ func extractParams(c *fasthttp.RequestCtx) (Params, error) {
 		params.ToBlock = ToBlock
 	}
 	if params.ToBlock == 0 {
-		params.ToBlock = 99999999
+		params.ToBlock = traverser.MAX_BLOCK
 	}
 
-	return params, nil
-}
+	params.FromTime = string(c.QueryArgs().Peek("fromTime"))
+	params.ToTime = string(c.QueryArgs().Peek("toTime"))
+
+	params.MaxHops = paths.DEFAULT_MAX_HOPS
+	for name, value := range map[string]*int{"maxHops": &params.MaxHops, "maxGap": &params.MaxGap, "maxPaths": &params.MaxPaths, "k": &params.K} {
+		str := string(c.QueryArgs().Peek(name))
+		if str == "" {
+			continue
+		}
+		v, err := strconv.Atoi(str)
+		if err != nil || v < 0 {
+			return params, errors.New(name + " invalid")
+		}
+		*value = v
+	}
+	if params.MaxHops == 0 || params.MaxHops > PATH_GRAPH_DFS_MAX_DEPTH {
+		return params, errors.New("maxHops invalid")
+	}
+
+	var err error
+	params.MaxDegree, params.NeverExpand, params.AlwaysExpand, err = hubParams(c)
+	if err != nil {
+		return params, err
+	}
+
+	params.Edges, err = edgeParams(c)
+	if err != nil {
+		return params, err
+	}
 
-func newDominikGraph(nodesMap map[string]bool, collapsedTrxs *[]schemas.CollapsedEdge) dominik.Graph[string, string] {
-	g := dominik.New(dominik.StringHash)
-	for n_hash := range nodesMap {
-		g.AddVertex(n_hash)
+	params.Search = string(c.QueryArgs().Peek("search"))
+	if params.Search == "" {
+		params.Search = SEARCH_TEMPORAL
 	}
-	for _, tx := range *collapsedTrxs {
-		g.AddEdge(tx.From, tx.To)
+	if params.Search != SEARCH_TEMPORAL && params.Search != SEARCH_SHORTEST {
+		return params, errors.New("search invalid")
 	}
-	return g
+	params.Rank = string(c.QueryArgs().Peek("rank"))
+	if params.Rank != "" && params.Rank != paths.RANK_LENGTH && params.Rank != paths.RANK_VALUE {
+		return params, errors.New("rank invalid")
+	}
+
+	return params, nil
 }
 
-func fetchPathAddresses(paths [][]string, params Params, redis *redis.RedisClient) []schemas.Node {
+func fetchPathAddresses(ctx context.Context, found []paths.Path, params Params, redis *redis.RedisClient) ([]schemas.Node, error) {
 	// fetch all nodes in the path, enrich with address-related data
-	pathNodes := []schemas.Node{}
-	if len(paths) == 0 {
-		// if there is no path between two addresses, we just return these two addresses
-		fromNode := utils.FetchAddress(params.FromHash, redis)
-		fromNode.Picked = true
-		toNode := utils.FetchAddress(params.ToHash, redis)
-		toNode.Picked = true
-		pathNodes = append(pathNodes, fromNode, toNode)
-	} else {
-		// if there is a path between two addresses, we return all nodes in the path
-		for i := range paths {
-			for _, pHash := range paths[i] {
-				node := utils.FetchAddress(pHash, redis)
-				node.Picked = params.ToHash == pHash || params.FromHash == pHash
-				pathNodes = append(pathNodes, node)
+	// if there is no path between two addresses, we just return these two addresses
+	addrs := []string{params.FromHash, params.ToHash}
+	if len(found) > 0 {
+		// if there is a path between two addresses, we return every node of the paths once
+		addrs = []string{}
+		seen := make(map[string]bool)
+		for i := range found {
+			for _, pHash := range found[i].Nodes {
+				if !seen[pHash] {
+					seen[pHash] = true
+					addrs = append(addrs, pHash)
+				}
 			}
 		}
 	}
-	return pathNodes
+	pathNodes, err := utils.FetchAddresses(ctx, addrs, redis)
+	if err != nil {
+		return nil, err
+	}
+	for i := range pathNodes {
+		pathNodes[i].Picked = params.ToHash == pathNodes[i].Id || params.FromHash == pathNodes[i].Id
+	}
+	return pathNodes, nil
 }
 
 const PATH_GRAPH_LIMIT = 500_000
 const PATH_GRAPH_DFS_MAX_DEPTH = 100
 
-func CollectPathHandler(c *fasthttp.RequestCtx) {
+func (h *Handler) CollectPathHandler(c *fasthttp.RequestCtx) {
 	start := time.Now()
 
 	params, vErr := extractParams(c)
@@ -119,72 +176,135 @@ func CollectPathHandler(c *fasthttp.RequestCtx) {
 		return
 	}
 
-	cfg, cfgErr := config.NewConfig()
+	ctx, cancel, err := h.requestContext(c, string(c.QueryArgs().Peek("timeout")))
+	if err != nil {
+		c.Error("Invalid timeout parameter", fasthttp.StatusBadRequest)
+		return
+	}
+	defer cancel()
 
-	if cfgErr != nil {
-		c.Error(cfgErr.Error(), fasthttp.StatusInternalServerError)
+	params.FromBlock, params.ToBlock, err = h.blockRange(ctx, params.FromBlock, params.ToBlock, params.FromTime, params.ToTime)
+	if err != nil {
+		writeRangeError(c, err)
 		return
 	}
-	redis := redis.NewClient(&cfg.Redis)
-
-	var graph *traverser.Graph
-	// dfsParams := traverser.ParamsDFS{
-	// 	Address:        params.ToHash,
-	// 	Depth:          PATH_GRAPH_DFS_MAX_DEPTH,
-	// 	FromBlock:      params.FromBlock,
-	// 	ToBlock:        params.ToBlock,
-	// 	Flow:           "input",
-	// 	GraphSizeLimit: PATH_GRAPH_LIMIT,
-	// }
-	dfsParams := traverser.ParamsDFS{
-		Address:        params.FromHash,
-		Depth:          PATH_GRAPH_DFS_MAX_DEPTH,
+
+	if params.Search == SEARCH_SHORTEST {
+		h.shortestPaths(ctx, c, params, start)
+		return
+	}
+
+	query := traverser.Query{
+		Roots: []string{params.FromHash},
+		// a path can't be longer than the graph is deep
+		Depth:          params.MaxHops,
+		Flow:           traverser.FLOW_OUTPUT,
 		FromBlock:      params.FromBlock,
 		ToBlock:        params.ToBlock,
-		Flow:           "output",
 		GraphSizeLimit: PATH_GRAPH_LIMIT,
+		MaxDegree:      params.MaxDegree,
+		NeverExpand:    params.NeverExpand,
+		AlwaysExpand:   params.AlwaysExpand,
+		Edges:          params.Edges,
+		Fetch:          h.fetchOptions(),
+	}
+	graph, err := h.collect(ctx, traverser.ALGO_DFS, query)
+	if errors.Is(err, traverser.ErrInvalidQuery) {
+		c.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
 	}
-
-	graph, err := traverser.CollectDFS(dfsParams, redis)
 	if err != nil {
-		c.Error("Error collecting graph dfs", fasthttp.StatusInternalServerError)
+		log.Err(err).Msg("CollectDFS failed")
+		c.Error("Error collecting graph dfs", errorStatus(err))
 		return
 	}
 
-	edges := []schemas.Edge{}
-	nodesMap := make(map[string]bool)
-	// restore adresses from transactions
+	h.logCacheStats()
+
+	log.Info().Msgf("dfs collected %d addresses and %d txs", len(*graph.Addrs), len(*graph.Txs))
+
+	txs := make([]traverser.Tx, 0, len(*graph.Txs))
 	for _, tx := range *graph.Txs {
-		_, exists := (nodesMap)[tx.From]
-		if !exists {
-			nodesMap[tx.From] = true
-		}
-		_, exists = (nodesMap)[tx.To]
-		if !exists {
-			nodesMap[tx.To] = true
-		}
-		edges = append(edges, schemas.Edge{From: tx.From, To: tx.To, Id: tx.TxHash, FlowByCurrency: tx.FlowByCurrency, TotalUsdFlow: tx.TotalUsdFlow})
+		txs = append(txs, tx)
 	}
-	collapsedTrxs := schemas.CollapseTxs(&edges)
-	log.Info().Msgf("dfs collected %d nodes and %d edges (%d collapsed)", len(nodesMap), len(edges), len(*collapsedTrxs))
-	g := newDominikGraph(nodesMap, collapsedTrxs)
+	// funds can only move forward in time
+	found := paths.Temporal(txs, params.FromHash, params.ToHash, paths.TemporalOptions{
+		MaxHops:  params.MaxHops,
+		MaxGap:   params.MaxGap,
+		MaxPaths: params.MaxPaths,
+	})
+	log.Info().Msgf("found %d temporal paths", len(found))
+	pathNodes, err := fetchPathAddresses(c, found, params, h.redis)
+	if err != nil {
+		c.Error("Error fetching addresses", errorStatus(err))
+		return
+	}
+	times := h.hopTimes(c, found)
 
-	// find all paths between two addresses
-	gSize, _ := g.Size()
-	log.Info().Msgf("start paths collecting graph size = %d", gSize)
+	writePathGraph(c, schemas.PathGraph{
+		Nodes:     pathNodes,
+		Edges:     schemas.NewPathEdges(found, times),
+		Paths:     schemas.NewPaths(found, times),
+		Truncated: graph.Truncated,
+	}, start)
+}
 
-	// ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	// defer cancel()
-	paths, err := utils.AllPathsBetween(g, params.FromHash, params.ToHash)
+// shortestPaths answers the path request with a bidirectional search bounded by the node budget
+func (h *Handler) shortestPaths(ctx context.Context, c *fasthttp.RequestCtx, params Params, start time.Time) {
+	result, err := paths.Search(ctx, params.FromHash, params.ToHash, paths.SearchOptions{
+		K:          params.K,
+		Rank:       params.Rank,
+		MaxHops:    params.MaxHops,
+		NodeBudget: h.cfg.Api.PathNodeBudget,
+		Query: traverser.Query{
+			FromBlock:    params.FromBlock,
+			ToBlock:      params.ToBlock,
+			MaxDegree:    params.MaxDegree,
+			NeverExpand:  params.NeverExpand,
+			AlwaysExpand: params.AlwaysExpand,
+			Edges:        params.Edges,
+			Fetch:        h.fetchOptions(),
+		},
+	}, h.redis)
+	if errors.Is(err, traverser.ErrInvalidQuery) {
+		c.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
 	if err != nil {
-		log.Err(err).Msg("Error collecting paths")
+		log.Err(err).Msg("Search failed")
+		c.Error("Error searching paths", errorStatus(err))
+		return
+	}
+
+	h.logCacheStats()
+
+	pathNodes, err := fetchPathAddresses(c, result.Paths, params, h.redis)
+	if err != nil {
+		c.Error("Error fetching addresses", errorStatus(err))
+		return
 	}
-	log.Info().Msgf("all paths %s", paths)
-	pathNodes := fetchPathAddresses(paths, params, redis)
+	times := h.hopTimes(c, result.Paths)
+
+	writePathGraph(c, schemas.PathGraph{
+		Nodes:     pathNodes,
+		Edges:     schemas.NewPathEdges(result.Paths, times),
+		Paths:     schemas.NewPaths(result.Paths, times),
+		Truncated: result.Truncated,
+	}, start)
+}
+
+// hopTimes returns timestamps of the blocks hops happened in
+func (h *Handler) hopTimes(ctx context.Context, found []paths.Path) map[int]int64 {
+	blocks := []int{}
+	for _, p := range found {
+		for _, hop := range p.Hops {
+			blocks = append(blocks, hop.Block)
+		}
+	}
+	return h.blockTimes(ctx, blocks)
+}
 
-	// paths edges are subset of all edges
-	// We just return all edges in the graph
-	data := schemas.GraphCollapsed{Nodes: pathNodes, Edges: *collapsedTrxs}
+func writePathGraph(c *fasthttp.RequestCtx, data schemas.PathGraph, start time.Time) {
 	jsonData, err := json.Marshal(data)
 	if err != nil {
 		c.Error("Error encoding JSON", fasthttp.StatusInternalServerError)
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+
+	"chain-traverser/api/handlers/schemas"
+	"chain-traverser/internal/analysis"
+	"chain-traverser/internal/traverser"
+)
+
+// PatternsHandler scores an address for fan-in, fan-out and structuring within a block range
+func (h *Handler) PatternsHandler(c *fasthttp.RequestCtx) {
+	start := time.Now()
+
+	address, ok := c.UserValue("address").(string)
+	if !ok || address == "" {
+		c.Error("Bad Request", fasthttp.StatusBadRequest)
+		return
+	}
+	ints := map[string]int{"fromBlock": 0, "toBlock": traverser.MAX_BLOCK, "minCounterparties": 0, "freshMaxTxs": 0}
+	for name := range ints {
+		str := string(c.QueryArgs().Peek(name))
+		if str == "" {
+			continue
+		}
+		value, err := strconv.Atoi(str)
+		if err != nil || value < 0 {
+			c.Error("Invalid "+name+" parameter", fasthttp.StatusBadRequest)
+			return
+		}
+		ints[name] = value
+	}
+	edges, err := edgeParams(c)
+	if err != nil {
+		c.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel, err := h.requestContext(c, string(c.QueryArgs().Peek("timeout")))
+	if err != nil {
+		c.Error("Invalid timeout parameter", fasthttp.StatusBadRequest)
+		return
+	}
+	defer cancel()
+
+	fromBlock, toBlock, err := h.blockRange(ctx, ints["fromBlock"], ints["toBlock"], string(c.QueryArgs().Peek("fromTime")), string(c.QueryArgs().Peek("toTime")))
+	if err != nil {
+		writeRangeError(c, err)
+		return
+	}
+
+	query := traverser.Query{
+		Roots:          []string{address},
+		FromBlock:      fromBlock,
+		ToBlock:        toBlock,
+		GraphSizeLimit: h.cfg.Api.GraphSizeLimit,
+		Edges:          edges,
+		Fetch:          h.fetchOptions(),
+	}
+	opts := analysis.SmurfOptions{MinCounterparties: ints["minCounterparties"], FreshMaxTxs: int64(ints["freshMaxTxs"])}
+	scores, err := analysis.ScoreAddress(ctx, query, opts, h.redis)
+	if errors.Is(err, traverser.ErrInvalidQuery) {
+		c.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Err(err).Msgf("pattern scoring of %s failed", address)
+		c.Error("Error scoring patterns", errorStatus(err))
+		return
+	}
+	h.logCacheStats()
+
+	data := schemas.FlowPatterns{
+		Address:         scores.Address,
+		FanIn:           scores.FanIn,
+		FanOut:          scores.FanOut,
+		Structuring:     scores.Structuring,
+		Senders:         scores.Senders,
+		Recipients:      scores.Recipients,
+		FreshSenders:    scores.FreshSenders,
+		FreshRecipients: scores.FreshRecipients,
+		Consolidation:   scores.Consolidation,
+		Source:          scores.Source,
+		InUsd:           scores.InUsd,
+		OutUsd:          scores.OutUsd,
+		FromBlock:       fromBlock,
+		ToBlock:         toBlock,
+	}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		c.Error("Error encoding JSON", fasthttp.StatusInternalServerError)
+		return
+	}
+	c.Write(jsonData)
+
+	c.SetContentType("application/json")
+	c.Response.Header.Set("Access-Control-Allow-Origin", "*")
+	c.Response.Header.Set("Access-Control-Allow-Methods", "GET")
+	c.Response.Header.Set("Access-Control-Allow-Headers", "Content-Type")
+	c.Response.SetStatusCode(fasthttp.StatusOK)
+
+	log.Info().Msgf("PatternsHandler %s in %s", address, time.Since(start))
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+
+	"chain-traverser/api/handlers/schemas"
+	"chain-traverser/api/handlers/utils"
+	"chain-traverser/internal/analysis"
+	"chain-traverser/internal/traverser"
+)
+
+// PeelChainHandler follows the dominant output of an address hop by hop and reports
+// the side transfers peeled off on the way
+func (h *Handler) PeelChainHandler(c *fasthttp.RequestCtx) {
+	start := time.Now()
+
+	source, ok := c.UserValue("address").(string)
+	if !ok || source == "" {
+		c.Error("Bad Request", fasthttp.StatusBadRequest)
+		return
+	}
+	ints := map[string]int{"fromBlock": 0, "toBlock": traverser.MAX_BLOCK, "maxHops": 0, "maxOutputs": 0, "maxGap": 0}
+	for name := range ints {
+		str := string(c.QueryArgs().Peek(name))
+		if str == "" {
+			continue
+		}
+		value, err := strconv.Atoi(str)
+		if err != nil || value < 0 {
+			c.Error("Invalid "+name+" parameter", fasthttp.StatusBadRequest)
+			return
+		}
+		ints[name] = value
+	}
+	var minShare float64
+	if minShareStr := string(c.QueryArgs().Peek("minShare")); minShareStr != "" {
+		var err error
+		minShare, err = strconv.ParseFloat(minShareStr, 64)
+		if err != nil {
+			c.Error("Invalid minShare parameter", fasthttp.StatusBadRequest)
+			return
+		}
+	}
+	maxDegree, neverExpand, alwaysExpand, err := hubParams(c)
+	if err != nil {
+		c.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	edges, err := edgeParams(c)
+	if err != nil {
+		c.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel, err := h.requestContext(c, string(c.QueryArgs().Peek("timeout")))
+	if err != nil {
+		c.Error("Invalid timeout parameter", fasthttp.StatusBadRequest)
+		return
+	}
+	defer cancel()
+
+	fromBlock, toBlock, err := h.blockRange(ctx, ints["fromBlock"], ints["toBlock"], string(c.QueryArgs().Peek("fromTime")), string(c.QueryArgs().Peek("toTime")))
+	if err != nil {
+		writeRangeError(c, err)
+		return
+	}
+
+	query := traverser.Query{
+		Roots:          []string{source},
+		FromBlock:      fromBlock,
+		ToBlock:        toBlock,
+		GraphSizeLimit: h.cfg.Api.GraphSizeLimit,
+		MaxDegree:      maxDegree,
+		NeverExpand:    neverExpand,
+		AlwaysExpand:   alwaysExpand,
+		Edges:          edges,
+		Fetch:          h.fetchOptions(),
+	}
+	opts := analysis.PeelOptions{
+		MaxHops:    ints["maxHops"],
+		MinShare:   minShare,
+		MaxOutputs: ints["maxOutputs"],
+		MaxGap:     ints["maxGap"],
+	}
+	chain, err := analysis.FollowPeelChain(ctx, query, opts, h.redis)
+	if errors.Is(err, traverser.ErrInvalidQuery) {
+		c.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Err(err).Msgf("peel chain from %s failed", source)
+		c.Error("Error following peel chain", errorStatus(err))
+		return
+	}
+	h.logCacheStats()
+
+	// chain addresses first, then the addresses peeled funds went to
+	addrs := []string{source}
+	blocks := []int{}
+	for _, hop := range chain.Hops {
+		addrs = append(addrs, hop.Forward.To)
+		blocks = append(blocks, hop.Forward.Block)
+	}
+	for _, hop := range chain.Hops {
+		for _, tx := range hop.Peels {
+			addrs = append(addrs, tx.To)
+			blocks = append(blocks, tx.Block)
+		}
+	}
+	unique := []string{}
+	seen := make(map[string]bool)
+	for _, addr := range addrs {
+		if !seen[addr] {
+			seen[addr] = true
+			unique = append(unique, addr)
+		}
+	}
+	nodes, err := utils.FetchAddresses(c, unique, h.redis)
+	if err != nil {
+		c.Error("Error fetching address", errorStatus(err))
+		return
+	}
+	for i := range nodes {
+		nodes[i].Picked = nodes[i].Id == source
+	}
+	times := h.blockTimes(c, blocks)
+
+	data := schemas.PeelChainResponse{
+		Start:       source,
+		Nodes:       nodes,
+		Hops:        schemas.NewPeelHops(chain, times),
+		Stop:        chain.Stop,
+		End:         chain.End,
+		PeelHops:    chain.PeelHops,
+		PeeledUsd:   chain.PeeledUsd,
+		IsPeelChain: chain.IsPeelChain,
+	}
+	if len(chain.Hops) > 0 {
+		data.FirstTimestamp = times[chain.Hops[0].Forward.Block]
+		data.LastTimestamp = times[chain.Hops[len(chain.Hops)-1].Forward.Block]
+	}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		c.Error("Error encoding JSON", fasthttp.StatusInternalServerError)
+		return
+	}
+	c.Write(jsonData)
+
+	c.SetContentType("application/json")
+	c.Response.Header.Set("Access-Control-Allow-Origin", "*")
+	c.Response.Header.Set("Access-Control-Allow-Methods", "GET")
+	c.Response.Header.Set("Access-Control-Allow-Headers", "Content-Type")
+	c.Response.SetStatusCode(fasthttp.StatusOK)
+
+	log.Info().Msgf("PeelChainHandler %s: %d hops in %s", source, len(chain.Hops), time.Since(start))
+}
@@ -2,22 +2,32 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
 
 	"github.com/valyala/fasthttp"
 
 	"chain-traverser/api/handlers/schemas"
 	"chain-traverser/api/handlers/utils"
-	"chain-traverser/internal/config"
-	"chain-traverser/internal/storage/redis"
+	"chain-traverser/internal/storage"
 	"chain-traverser/internal/traverser"
 )
 
-func CollectGraphHandler(c *fasthttp.RequestCtx) {
+// storage outages are reported as 503 so clients can retry later
+func errorStatus(err error) int {
+	if errors.Is(err, storage.ErrUnavailable) {
+		return fasthttp.StatusServiceUnavailable
+	}
+	return fasthttp.StatusInternalServerError
+}
+
+func (h *Handler) CollectGraphHandler(c *fasthttp.RequestCtx) {
 	start := time.Now()
 
 	targetHash := c.UserValue("address")
@@ -54,7 +64,7 @@ func CollectGraphHandler(c *fasthttp.RequestCtx) {
 	toBlockStr := string(c.QueryArgs().Peek("toBlock"))
 	var toBlock int
 	if toBlockStr == "" {
-		toBlock = 99999999
+		toBlock = traverser.MAX_BLOCK
 	} else {
 		toBlock, err = strconv.Atoi(toBlockStr)
 		if err != nil {
@@ -65,16 +75,55 @@ func CollectGraphHandler(c *fasthttp.RequestCtx) {
 
 	algoStr := string(c.QueryArgs().Peek("algo"))
 	log.Info().Msgf("algoStr: %s", algoStr)
-	var algo string
-	if algoStr == "bfs" {
-		algo = "bfs"
-	} else {
-		algo = "dfs"
+	algo := traverser.ALGO_DFS
+	if algoStr == traverser.ALGO_BFS || algoStr == traverser.ALGO_MONEY {
+		algo = algoStr
+	}
+
+	minEdgeUsd := decimal.Zero
+	if minEdgeUsdStr := string(c.QueryArgs().Peek("minEdgeUsd")); minEdgeUsdStr != "" {
+		minEdgeUsd, err = decimal.NewFromString(minEdgeUsdStr)
+		if err != nil {
+			c.Error("Invalid minEdgeUsd parameter", fasthttp.StatusBadRequest)
+			return
+		}
+	}
+	var minTracedFraction float64
+	if fractionStr := string(c.QueryArgs().Peek("minTracedFraction")); fractionStr != "" {
+		minTracedFraction, err = strconv.ParseFloat(fractionStr, 64)
+		if err != nil {
+			c.Error("Invalid minTracedFraction parameter", fasthttp.StatusBadRequest)
+			return
+		}
+	}
+
+	maxDegree, neverExpand, alwaysExpand, err := hubParams(c)
+	if err != nil {
+		c.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	edges, err := edgeParams(c)
+	if err != nil {
+		c.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
 	}
 
-	flowOptions := []string{"input", "output", "all"}
+	clusterBy, clusterMinSize, err := clusterParams(c)
+	if err != nil {
+		c.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	analysisWeight, err := analysisParam(c)
+	if err != nil {
+		c.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	flowOptions := []string{traverser.FLOW_INPUT, traverser.FLOW_OUTPUT, traverser.FLOW_ALL}
 	flowStr := string(c.QueryArgs().Peek("flow"))
-	log.Info().Msgf("flowStr: %s", algoStr)
+	log.Info().Msgf("flowStr: %s", flowStr)
 	var flow string
 	if slices.Contains(flowOptions, flowStr) {
 		flow = flowStr
@@ -83,45 +132,147 @@ func CollectGraphHandler(c *fasthttp.RequestCtx) {
 		return
 	}
 
-	var graph *traverser.Graph
+	ctx, cancel, err := h.requestContext(c, string(c.QueryArgs().Peek("timeout")))
+	if err != nil {
+		c.Error("Invalid timeout parameter", fasthttp.StatusBadRequest)
+		return
+	}
+	defer cancel()
 
-	cfg, err := config.NewConfig()
+	fromBlock, toBlock, err = h.blockRange(ctx, fromBlock, toBlock, string(c.QueryArgs().Peek("fromTime")), string(c.QueryArgs().Peek("toTime")))
+	if err != nil {
+		writeRangeError(c, err)
+		return
+	}
 
+	query := traverser.Query{
+		Roots:             []string{targetHash.(string)},
+		Depth:             depth,
+		Flow:              flow,
+		FromBlock:         fromBlock,
+		ToBlock:           toBlock,
+		GraphSizeLimit:    h.cfg.Api.GraphSizeLimit,
+		MaxDegree:         maxDegree,
+		NeverExpand:       neverExpand,
+		AlwaysExpand:      alwaysExpand,
+		Edges:             edges,
+		MinEdgeUsd:        minEdgeUsd,
+		MinTracedFraction: minTracedFraction,
+		Fetch:             h.fetchOptions(),
+	}
+	graph, err := h.collect(ctx, algo, query)
+	if errors.Is(err, traverser.ErrInvalidQuery) {
+		c.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
 	if err != nil {
-		log.Err(err).Msg("error reading config")
+		log.Err(err).Msgf("%s traversal failed", algo)
+		c.Error("Error collecting graph", errorStatus(err))
 		return
 	}
-	redis := redis.NewClient(&cfg.Redis)
+	err = h.clusterLeaves(ctx, graph, query, clusterBy, clusterMinSize)
+	if errors.Is(err, traverser.ErrInvalidQuery) {
+		c.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Err(err).Msg("clustering failed")
+		c.Error("Error clustering graph", errorStatus(err))
+		return
+	}
+
+	h.writeGraph(c, graph, query, collapseTrxs, analysisWeight, start)
+}
+
+// writeRangeError answers a request whose time range can't be resolved to blocks
+func writeRangeError(c *fasthttp.RequestCtx, err error) {
+	if errors.Is(err, ErrTimeRange) {
+		c.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	log.Err(err).Msg("Cant resolve time range")
+	c.Error("Error resolving time range", errorStatus(err))
+}
 
-	if algo == "dfs" {
-		dfsParams := traverser.ParamsDFS{
-			Address:        targetHash.(string),
-			Depth:          depth,
-			FromBlock:      fromBlock,
-			ToBlock:        toBlock,
-			Flow:           flow,
-			GraphSizeLimit: 5_000,
+// hubParams parses the degree limit and the label rules deciding which addresses are expanded,
+// a rule param given empty disables the default rules
+func hubParams(c *fasthttp.RequestCtx) (int, []string, []string, error) {
+	var maxDegree int
+	if maxDegreeStr := string(c.QueryArgs().Peek("maxDegree")); maxDegreeStr != "" {
+		var err error
+		maxDegree, err = strconv.Atoi(maxDegreeStr)
+		if err != nil || maxDegree < 0 {
+			return 0, nil, nil, errors.New("Invalid maxDegree parameter")
+		}
+	}
+	labelRules := func(name string) []string {
+		if !c.QueryArgs().Has(name) {
+			return nil
 		}
+		return listParam(c, name)
+	}
+	return maxDegree, labelRules("neverExpand"), labelRules("alwaysExpand"), nil
+}
 
-		graph, err = traverser.CollectDFS(dfsParams, redis)
-		if err != nil {
-			c.Error("Error collecting graph dfs", fasthttp.StatusInternalServerError)
-			return
+// listParam splits a comma separated query param
+func listParam(c *fasthttp.RequestCtx, name string) []string {
+	values := []string{}
+	for _, value := range strings.Split(string(c.QueryArgs().Peek(name)), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
 		}
-	} else {
-		graph, err = traverser.CollectBFS(targetHash.(string), depth, fromBlock, toBlock, redis)
+	}
+	return values
+}
+
+// edgeParams parses the filter of transactions a traversal follows
+func edgeParams(c *fasthttp.RequestCtx) (traverser.EdgeFilter, error) {
+	filter := traverser.EdgeFilter{
+		Currencies:        listParam(c, "currencies"),
+		CounterpartyTypes: listParam(c, "counterpartyTypes"),
+	}
+	amounts := []struct {
+		name  string
+		value *decimal.Decimal
+	}{
+		{"minUsd", &filter.MinUsd},
+		{"maxUsd", &filter.MaxUsd},
+		{"minAmount", &filter.MinAmount},
+		{"maxAmount", &filter.MaxAmount},
+	}
+	for _, amount := range amounts {
+		str := string(c.QueryArgs().Peek(amount.name))
+		if str == "" {
+			continue
+		}
+		value, err := decimal.NewFromString(str)
 		if err != nil {
-			c.Error("Error collecting graph", fasthttp.StatusInternalServerError)
-			return
+			return filter, errors.New("Invalid " + amount.name + " parameter")
 		}
-
+		*amount.value = value
 	}
+	return filter, nil
+}
 
+// writeGraph responds with the graph, nodes are enriched with counters and labels.
+// Addresses visited by an earlier request are left out of the nodes, transactions between two of them out of the edges.
+// A non empty analysis weight scores the nodes, see the analysis package.
+func (h *Handler) writeGraph(c *fasthttp.RequestCtx, graph *traverser.Graph, query traverser.Query, collapseTrxs bool, analysisWeight string, start time.Time) {
 	nodes := []schemas.Node{}
 	edges := []schemas.Edge{}
 	nodesMap := make(map[string]bool)
 
+	blocks := make([]int, 0, len(*graph.Txs))
+	for _, tx := range *graph.Txs {
+		blocks = append(blocks, tx.Block)
+	}
+	times := h.blockTimes(c, blocks)
+
 	for _, tx := range *graph.Txs {
+		if query.Visited[tx.From] && query.Visited[tx.To] {
+			// the client has it already
+			continue
+		}
 		_, exists := (nodesMap)[tx.From]
 		if !exists {
 			nodesMap[tx.From] = true
@@ -130,20 +281,65 @@ func CollectGraphHandler(c *fasthttp.RequestCtx) {
 		if !exists {
 			nodesMap[tx.To] = true
 		}
-		edges = append(edges, schemas.Edge{From: tx.From, To: tx.To, Id: tx.TxHash, FlowByCurrency: tx.FlowByCurrency, TotalUsdFlow: tx.TotalUsdFlow})
+		edges = append(edges, schemas.Edge{
+			From:           tx.From,
+			To:             tx.To,
+			Id:             tx.TxHash,
+			Block:          tx.Block,
+			Timestamp:      times[tx.Block],
+			FlowByCurrency: tx.FlowByCurrency,
+			TotalUsdFlow:   tx.TotalUsdFlow,
+		})
+	}
+	// seeds without transactions are still part of the graph
+	for _, root := range query.Roots {
+		nodesMap[root] = true
+	}
+
+	scores, summary, err := analyzeGraph(graph, analysisWeight)
+	if err != nil {
+		log.Err(err).Msg("graph analysis failed")
+		c.Error("Error analysing graph", fasthttp.StatusInternalServerError)
+		return
 	}
 
+	addrs := []string{}
 	for n_hash := range nodesMap {
-		node := utils.FetchAddress(n_hash, redis)
-		node.Picked = targetHash == n_hash
+		if query.Visited[n_hash] {
+			// the client has it already
+			continue
+		}
+		if cluster, ok := graph.Clusters[n_hash]; ok {
+			node := clusterNode(cluster)
+			node.Scores = nodeScores(scores, n_hash)
+			nodes = append(nodes, node)
+			continue
+		}
+		addrs = append(addrs, n_hash)
+	}
+	// the budget covers the traversal only, nodes of a partial graph are still returned
+	addrNodes, err := utils.FetchAddresses(c, addrs, h.redis)
+	if err != nil {
+		c.Error("Error fetching address", errorStatus(err))
+		return
+	}
+	for _, node := range addrNodes {
+		node.Picked = slices.Contains(query.Roots, node.Id)
+		node.Seeds = graph.Seeds[node.Id]
+		node.Skipped = (*graph.Addrs)[node.Id].Skipped
+		node.Scores = nodeScores(scores, node.Id)
 		nodes = append(nodes, node)
 	}
+	h.logCacheStats()
+	if graph.Truncated != "" {
+		log.Warn().Msgf("graph of %v truncated: %s after %s", query.Roots, graph.Truncated, time.Since(start))
+	}
 
 	if collapseTrxs {
 		collapsedTrxs := schemas.CollapseTxs(&edges)
 
 		log.Info().Msgf("got %d collapsed transactions in %s", len(*collapsedTrxs), time.Since(start))
-		data := schemas.GraphCollapsed{Nodes: nodes, Edges: *collapsedTrxs}
+		data := schemas.GraphCollapsed{Nodes: nodes, Edges: *collapsedTrxs, Truncated: graph.Truncated, Analysis: summary}
 		jsonData, err := json.Marshal(data)
 		if err != nil {
 			c.Error("Error encoding JSON", fasthttp.StatusInternalServerError)
@@ -152,7 +348,7 @@ func CollectGraphHandler(c *fasthttp.RequestCtx) {
 		c.Write(jsonData)
 
 	} else {
-		data := schemas.Graph{Nodes: nodes, Edges: edges}
+		data := schemas.Graph{Nodes: nodes, Edges: edges, Truncated: graph.Truncated, Analysis: summary}
 		jsonData, err := json.Marshal(data)
 		if err != nil {
 			c.Error("Error encoding JSON", fasthttp.StatusInternalServerError)
@@ -164,7 +360,7 @@ func CollectGraphHandler(c *fasthttp.RequestCtx) {
 
 	c.SetContentType("application/json")
 	c.Response.Header.Set("Access-Control-Allow-Origin", "*")
-	c.Response.Header.Set("Access-Control-Allow-Methods", "GET")
+	c.Response.Header.Set("Access-Control-Allow-Methods", "GET, POST")
 	c.Response.Header.Set("Access-Control-Allow-Headers", "Content-Type")
 	c.Response.SetStatusCode(fasthttp.StatusOK)
 }
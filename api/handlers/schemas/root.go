@@ -1,6 +1,9 @@
 package schemas
 
 import (
+	"chain-traverser/internal/analysis"
+	"chain-traverser/internal/paths"
+	"chain-traverser/internal/traverser"
 	"strconv"
 
 	"github.com/shopspring/decimal"
@@ -12,12 +15,102 @@ type Node struct {
 	Cnt    int64  `json:"cnt"`
 	Picked bool   `json:"picked"`
 	Type   string `json:"type"`
+	// seed -> distance for every seed reaching the node
+	Seeds map[string]int `json:"seeds,omitempty"`
+	// why the traversal didn't expand the node: max_degree or label
+	Skipped string `json:"skipped,omitempty"`
+	// set on nodes standing for a group of leaves
+	Cluster *ClusterSummary `json:"cluster,omitempty"`
+	// set when the request asks for an analysis and the node has transactions
+	Scores *NodeScores `json:"scores,omitempty"`
+}
+
+type NodeScores struct {
+	PageRank    float64 `json:"pagerank"`
+	Betweenness float64 `json:"betweenness"`
+	// ids of the weakly and strongly connected components and of the community
+	Component       int `json:"component"`
+	StrongComponent int `json:"strong_component"`
+	Community       int `json:"community"`
+}
+
+// GraphAnalysis describes the analysis the node scores come from
+type GraphAnalysis struct {
+	Weight      string  `json:"weight"` // usd or count
+	Components  int     `json:"components"`
+	Communities int     `json:"communities"`
+	Modularity  float64 `json:"modularity"`
+}
+
+type ClusterSummary struct {
+	Anchor string `json:"anchor"`
+	// output if the anchor sends to the members, input if it receives from them
+	Direction      string                     `json:"direction"`
+	Key            string                     `json:"key"` // label kind or currencies the members share
+	Members        int                        `json:"members"`
+	Txs            int                        `json:"txs"`
+	FlowByCurrency map[string]decimal.Decimal `json:"flow_by_currency"`
+	TotalUsdFlow   decimal.Decimal            `json:"total_usd_flow"`
+}
+
+type ClusterMembers struct {
+	Id    string `json:"id"`
+	Nodes []Node `json:"nodes"`
+}
+
+// SeedsRequest is the body of a multi-source traversal
+type SeedsRequest struct {
+	Seeds        []string `json:"seeds"`
+	Depth        int      `json:"depth"`
+	Flow         string   `json:"flow"`
+	FromBlock    int      `json:"fromBlock"`
+	ToBlock      int      `json:"toBlock"`
+	Algo         string   `json:"algo"`
+	CollapseTrxs *bool    `json:"collapseTrxs"`
+	Timeout      string   `json:"timeout"`
+	// 0 keeps the default, label rules replace the defaults when set, [] disables them
+	MaxDegree    int      `json:"maxDegree"`
+	NeverExpand  []string `json:"neverExpand"`
+	AlwaysExpand []string `json:"alwaysExpand"`
+	// edge filter, zero values leave an option unset
+	Currencies        []string        `json:"currencies"`
+	MinUsd            decimal.Decimal `json:"minUsd"`
+	MaxUsd            decimal.Decimal `json:"maxUsd"`
+	MinAmount         decimal.Decimal `json:"minAmount"`
+	MaxAmount         decimal.Decimal `json:"maxAmount"`
+	CounterpartyTypes []string        `json:"counterpartyTypes"`
+	// money traversal only
+	MinEdgeUsd        decimal.Decimal `json:"minEdgeUsd"`
+	MinTracedFraction float64         `json:"minTracedFraction"`
+
+	// RFC 3339 or unix seconds, narrow the block range
+	FromTime string `json:"fromTime"`
+	ToTime   string `json:"toTime"`
+
+	// "label" or "behaviour" groups leaves into cluster nodes
+	Cluster        string `json:"cluster"`
+	ClusterMinSize int    `json:"clusterMinSize"`
+
+	// "usd" or "count" scores nodes with edges weighted accordingly
+	Analysis string `json:"analysis"`
+}
+
+// ExpandRequest grows a graph the client holds, it takes the options of
+// SeedsRequest, seeds excepted
+type ExpandRequest struct {
+	SeedsRequest
+	// addresses to expand
+	Expand []string `json:"expand"`
+	// addresses already in the graph of the client
+	Visited []string `json:"visited"`
 }
 
 type Edge struct {
 	Id             string                     `json:"id"`
 	From           string                     `json:"start"`
 	To             string                     `json:"end"`
+	Block          int                        `json:"block"`
+	Timestamp      int64                      `json:"timestamp,omitempty"` // unix time of the block
 	FlowByCurrency map[string]decimal.Decimal `json:"flow_by_currency"`
 	TotalUsdFlow   decimal.Decimal            `json:"total_usd_flow"`
 }
@@ -30,16 +123,191 @@ type CollapsedEdge struct {
 	Count          int                        `json:"value"`
 	FlowByCurrency map[string]decimal.Decimal `json:"flow_by_currency"`
 	TotalUsdFlow   decimal.Decimal            `json:"total_usd_flow"`
+	// unix times of the first and the last collapsed tx
+	FirstTimestamp int64 `json:"first_timestamp,omitempty"`
+	LastTimestamp  int64 `json:"last_timestamp,omitempty"`
 }
 
 type GraphCollapsed struct {
 	Nodes []Node          `json:"nodes"`
 	Edges []CollapsedEdge `json:"edges"`
+	// set when the traversal ran out of time and the graph is partial
+	Truncated string         `json:"truncated,omitempty"`
+	Analysis  *GraphAnalysis `json:"analysis,omitempty"`
 }
 
 type Graph struct {
 	Nodes []Node `json:"nodes"`
 	Edges []Edge `json:"edges"`
+	// set when the traversal ran out of time and the graph is partial
+	Truncated string         `json:"truncated,omitempty"`
+	Analysis  *GraphAnalysis `json:"analysis,omitempty"`
+}
+
+type AddressTaint struct {
+	Received decimal.Decimal `json:"received"`
+	Held     decimal.Decimal `json:"held"`
+}
+
+type TaintNode struct {
+	Node
+	// currency -> tainted amounts
+	Taint map[string]AddressTaint `json:"taint"`
+}
+
+type TaintEdge struct {
+	Id        string `json:"id"`
+	From      string `json:"start"`
+	To        string `json:"end"`
+	Block     int    `json:"block"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+	// currency -> tainted amount moved by the tx
+	Tainted        map[string]decimal.Decimal `json:"tainted"`
+	FlowByCurrency map[string]decimal.Decimal `json:"flow_by_currency"`
+}
+
+type TaintResponse struct {
+	Model     string      `json:"model"`
+	Source    string      `json:"source"`
+	Block     int         `json:"block"`
+	Nodes     []TaintNode `json:"nodes"`
+	Edges     []TaintEdge `json:"edges"`
+	Truncated string      `json:"truncated,omitempty"`
+}
+
+type Cycle struct {
+	// addresses in hop order, starting with the root funds return to
+	Nodes          []string        `json:"nodes"`
+	Hops           []Hop           `json:"hops"`
+	TotalUsdFlow   decimal.Decimal `json:"total_usd_flow"`
+	ReturnedUsd    decimal.Decimal `json:"returned_usd"` // USD of the hop closing the cycle
+	FirstBlock     int             `json:"first_block"`
+	LastBlock      int             `json:"last_block"`
+	FirstTimestamp int64           `json:"first_timestamp,omitempty"`
+	LastTimestamp  int64           `json:"last_timestamp,omitempty"`
+	// round trips over the same route that don't overlap in time
+	Repeats int `json:"repeats"`
+}
+
+type CyclesResponse struct {
+	Root      string  `json:"root"`
+	MaxLength int     `json:"max_length"`
+	Nodes     []Node  `json:"nodes"`
+	Cycles    []Cycle `json:"cycles"`
+	Truncated string  `json:"truncated,omitempty"`
+}
+
+type PeelHop struct {
+	Address string `json:"address"`
+	// output received by the next address of the chain
+	Forward    Hop             `json:"forward"`
+	Peels      []Hop           `json:"peels"`
+	ForwardUsd decimal.Decimal `json:"forward_usd"`
+	PeeledUsd  decimal.Decimal `json:"peeled_usd"`
+	Share      float64         `json:"share"` // forwarded share of the USD the hop sent
+}
+
+type PeelChainResponse struct {
+	Start string    `json:"start"`
+	Nodes []Node    `json:"nodes"`
+	Hops  []PeelHop `json:"hops"`
+	// why the chain ended and the last address reached
+	Stop           string          `json:"stop"`
+	End            string          `json:"end"`
+	PeelHops       int             `json:"peel_hops"`
+	PeeledUsd      decimal.Decimal `json:"peeled_usd"`
+	IsPeelChain    bool            `json:"is_peel_chain"`
+	FirstTimestamp int64           `json:"first_timestamp,omitempty"`
+	LastTimestamp  int64           `json:"last_timestamp,omitempty"`
+}
+
+type FlowPatterns struct {
+	Address string `json:"address"`
+	// pattern scores within 0..1
+	FanIn       float64 `json:"fan_in"`
+	FanOut      float64 `json:"fan_out"`
+	Structuring float64 `json:"structuring"`
+	Senders     int     `json:"senders"`
+	Recipients  int     `json:"recipients"`
+	// participants: fresh counterparties, largest recipient and largest sender
+	FreshSenders    []string        `json:"fresh_senders"`
+	FreshRecipients []string        `json:"fresh_recipients"`
+	Consolidation   string          `json:"consolidation,omitempty"`
+	Source          string          `json:"source,omitempty"`
+	InUsd           decimal.Decimal `json:"in_usd"`
+	OutUsd          decimal.Decimal `json:"out_usd"`
+	FromBlock       int             `json:"from_block"`
+	ToBlock         int             `json:"to_block"`
+}
+
+type Hop struct {
+	Id             string                     `json:"id"`
+	From           string                     `json:"start"`
+	To             string                     `json:"end"`
+	Block          int                        `json:"block"`
+	Timestamp      int64                      `json:"timestamp,omitempty"`
+	FlowByCurrency map[string]decimal.Decimal `json:"flow_by_currency"`
+	TotalUsdFlow   decimal.Decimal            `json:"total_usd_flow"`
+}
+
+type Path struct {
+	Nodes []string `json:"nodes"`
+	Hops  []Hop    `json:"hops"`
+	// USD amount the whole path can carry, the smallest hop
+	ValueUsd decimal.Decimal `json:"value_usd"`
+}
+
+type PathGraph struct {
+	Nodes []Node `json:"nodes"`
+	// txs used by at least one hop, hops refer to them by id
+	Edges     []Edge `json:"edges"`
+	Paths     []Path `json:"paths"`
+	Truncated string `json:"truncated,omitempty"`
+}
+
+// NewPaths converts paths, times holds unix timestamps of hop blocks
+func NewPaths(found []paths.Path, times map[int]int64) []Path {
+	result := make([]Path, 0, len(found))
+	for _, p := range found {
+		hops := make([]Hop, len(p.Hops))
+		for i, hop := range p.Hops {
+			hops[i] = Hop{
+				Id:             hop.TxHash,
+				From:           hop.From,
+				To:             hop.To,
+				Block:          hop.Block,
+				Timestamp:      times[hop.Block],
+				FlowByCurrency: hop.FlowByCurrency,
+				TotalUsdFlow:   hop.TotalUsdFlow,
+			}
+		}
+		result = append(result, Path{Nodes: p.Nodes, Hops: hops, ValueUsd: p.Value()})
+	}
+	return result
+}
+
+// NewPathEdges returns every tx lying on one of the paths once, in path order
+func NewPathEdges(found []paths.Path, times map[int]int64) []Edge {
+	edges := []Edge{}
+	seen := make(map[string]bool)
+	for _, p := range found {
+		for _, hop := range p.Hops {
+			if seen[hop.TxHash] {
+				continue
+			}
+			seen[hop.TxHash] = true
+			edges = append(edges, Edge{
+				Id:             hop.TxHash,
+				From:           hop.From,
+				To:             hop.To,
+				Block:          hop.Block,
+				Timestamp:      times[hop.Block],
+				FlowByCurrency: hop.FlowByCurrency,
+				TotalUsdFlow:   hop.TotalUsdFlow,
+			})
+		}
+	}
+	return edges
 }
 
 func CollapseTxs(txs *[]Edge) *[]CollapsedEdge {
@@ -55,14 +323,27 @@ func CollapseTxs(txs *[]Edge) *[]CollapsedEdge {
 			for currency, amount := range tx.FlowByCurrency {
 				edge.FlowByCurrency[currency] = edge.FlowByCurrency[currency].Add(amount)
 			}
+			if tx.Timestamp != 0 {
+				if edge.FirstTimestamp == 0 || tx.Timestamp < edge.FirstTimestamp {
+					edge.FirstTimestamp = tx.Timestamp
+				}
+				edge.LastTimestamp = max(edge.LastTimestamp, tx.Timestamp)
+			}
 			txsMap[key] = edge
 		} else {
+			// txs may come from the shared block cache, sum into a copy
+			flowByCurrency := make(map[string]decimal.Decimal, len(tx.FlowByCurrency))
+			for currency, amount := range tx.FlowByCurrency {
+				flowByCurrency[currency] = amount
+			}
 			txsMap[key] = CollapsedEdge{
 				From:           tx.From,
 				To:             tx.To,
 				Count:          1,
-				FlowByCurrency: tx.FlowByCurrency,
+				FlowByCurrency: flowByCurrency,
 				TotalUsdFlow:   tx.TotalUsdFlow,
+				FirstTimestamp: tx.Timestamp,
+				LastTimestamp:  tx.Timestamp,
 				Id:             strconv.Itoa(cnt),
 			}
 			cnt++
@@ -77,3 +358,58 @@ func CollapseTxs(txs *[]Edge) *[]CollapsedEdge {
 
 	return &collapsedTxs
 }
+
+func newHop(tx traverser.Tx, times map[int]int64) Hop {
+	return Hop{
+		Id:             tx.TxHash,
+		From:           tx.From,
+		To:             tx.To,
+		Block:          tx.Block,
+		Timestamp:      times[tx.Block],
+		FlowByCurrency: tx.FlowByCurrency,
+		TotalUsdFlow:   tx.TotalUsdFlow,
+	}
+}
+
+// NewPeelHops converts the hops of a peel chain, times holds unix timestamps of their blocks
+func NewPeelHops(chain *analysis.PeelChain, times map[int]int64) []PeelHop {
+	hops := make([]PeelHop, 0, len(chain.Hops))
+	for _, hop := range chain.Hops {
+		peels := make([]Hop, 0, len(hop.Peels))
+		for _, tx := range hop.Peels {
+			peels = append(peels, newHop(tx, times))
+		}
+		hops = append(hops, PeelHop{
+			Address:    hop.Address,
+			Forward:    newHop(hop.Forward, times),
+			Peels:      peels,
+			ForwardUsd: hop.ForwardUsd,
+			PeeledUsd:  hop.PeeledUsd,
+			Share:      hop.Share,
+		})
+	}
+	return hops
+}
+
+// NewCycles converts the earliest occurrence of every cycle, times holds unix timestamps of hop blocks
+func NewCycles(found []analysis.Cycle, times map[int]int64) []Cycle {
+	result := make([]Cycle, 0, len(found))
+	for _, cycle := range found {
+		hops := make([]Hop, len(cycle.Txs))
+		for i, tx := range cycle.Txs {
+			hops[i] = newHop(tx, times)
+		}
+		result = append(result, Cycle{
+			Nodes:          cycle.Route,
+			Hops:           hops,
+			TotalUsdFlow:   cycle.TotalUsdFlow,
+			ReturnedUsd:    cycle.ReturnedUsd,
+			FirstBlock:     cycle.FirstBlock,
+			LastBlock:      cycle.LastBlock,
+			FirstTimestamp: times[cycle.FirstBlock],
+			LastTimestamp:  times[cycle.LastBlock],
+			Repeats:        cycle.Repeats,
+		})
+	}
+	return result
+}
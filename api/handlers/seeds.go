@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+
+	"chain-traverser/api/handlers/schemas"
+	"chain-traverser/internal/traverser"
+)
+
+// CollectSeedsHandler traverses from a set of seed addresses given in the JSON body,
+// seed lists of real investigations don't fit in a URL
+func (h *Handler) CollectSeedsHandler(c *fasthttp.RequestCtx) {
+	start := time.Now()
+
+	var req schemas.SeedsRequest
+	if err := json.Unmarshal(c.PostBody(), &req); err != nil {
+		c.Error("Invalid JSON body", fasthttp.StatusBadRequest)
+		return
+	}
+	if len(req.Seeds) == 0 {
+		c.Error("seeds required", fasthttp.StatusBadRequest)
+		return
+	}
+	if len(req.Seeds) > h.cfg.Api.MaxSeeds {
+		c.Error(fmt.Sprintf("too many seeds, max %d", h.cfg.Api.MaxSeeds), fasthttp.StatusBadRequest)
+		return
+	}
+	h.seedsGraph(c, req, nil, start)
+}
+
+// seedsGraph traverses from the seeds of req and responds with the graph,
+// visited addresses are neither expanded nor returned
+func (h *Handler) seedsGraph(c *fasthttp.RequestCtx, req schemas.SeedsRequest, visited map[string]bool, start time.Time) {
+	algo := traverser.ALGO_DFS
+	if req.Algo != "" {
+		algo = req.Algo
+	}
+	collapseTrxs := req.CollapseTrxs == nil || *req.CollapseTrxs
+	if err := checkAnalysis(req.Analysis); err != nil {
+		c.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel, err := h.requestContext(c, req.Timeout)
+	if err != nil {
+		c.Error("Invalid timeout parameter", fasthttp.StatusBadRequest)
+		return
+	}
+	defer cancel()
+
+	toBlock := req.ToBlock
+	if toBlock == 0 {
+		toBlock = traverser.MAX_BLOCK
+	}
+	fromBlock, toBlock, err := h.blockRange(ctx, req.FromBlock, toBlock, req.FromTime, req.ToTime)
+	if err != nil {
+		writeRangeError(c, err)
+		return
+	}
+
+	edges := traverser.EdgeFilter{
+		Currencies:        req.Currencies,
+		MinUsd:            req.MinUsd,
+		MaxUsd:            req.MaxUsd,
+		MinAmount:         req.MinAmount,
+		MaxAmount:         req.MaxAmount,
+		CounterpartyTypes: req.CounterpartyTypes,
+	}
+	query := traverser.Query{
+		Roots:             dedupSeeds(req.Seeds),
+		Depth:             req.Depth,
+		Flow:              req.Flow,
+		FromBlock:         fromBlock,
+		ToBlock:           toBlock,
+		GraphSizeLimit:    h.cfg.Api.GraphSizeLimit,
+		MaxDegree:         req.MaxDegree,
+		NeverExpand:       req.NeverExpand,
+		AlwaysExpand:      req.AlwaysExpand,
+		Edges:             edges,
+		MinEdgeUsd:        req.MinEdgeUsd,
+		MinTracedFraction: req.MinTracedFraction,
+		Visited:           visited,
+		Fetch:             h.fetchOptions(),
+	}
+	graph, err := h.collect(ctx, algo, query)
+	if errors.Is(err, traverser.ErrInvalidQuery) {
+		c.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Err(err).Msgf("%s traversal of %d seeds failed", algo, len(query.Roots))
+		c.Error("Error collecting graph", errorStatus(err))
+		return
+	}
+	err = h.clusterLeaves(ctx, graph, query, req.Cluster, req.ClusterMinSize)
+	if errors.Is(err, traverser.ErrInvalidQuery) {
+		c.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Err(err).Msg("clustering failed")
+		c.Error("Error clustering graph", errorStatus(err))
+		return
+	}
+	h.writeGraph(c, graph, query, collapseTrxs, req.Analysis, start)
+}
+
+// dedupSeeds keeps the first occurrence of every seed
+func dedupSeeds(seeds []string) []string {
+	seen := make(map[string]bool)
+	unique := []string{}
+	for _, seed := range seeds {
+		if seed != "" && !seen[seed] {
+			seen[seed] = true
+			unique = append(unique, seed)
+		}
+	}
+	return unique
+}
+
+// CorsPreflight answers the browser OPTIONS request sent before a JSON POST
+func CorsPreflight(c *fasthttp.RequestCtx) {
+	c.Response.Header.Set("Access-Control-Allow-Origin", "*")
+	c.Response.Header.Set("Access-Control-Allow-Methods", "GET, POST")
+	c.Response.Header.Set("Access-Control-Allow-Headers", "Content-Type")
+	c.Response.SetStatusCode(fasthttp.StatusNoContent)
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+
+	"chain-traverser/api/handlers/schemas"
+	"chain-traverser/api/handlers/utils"
+	"chain-traverser/internal/taint"
+	"chain-traverser/internal/traverser"
+)
+
+const TAINT_DEFAULT_DEPTH = 3
+
+// TaintHandler reports how the funds a source sent from a block on spread,
+// only addresses and txs that received tainted funds are returned
+func (h *Handler) TaintHandler(c *fasthttp.RequestCtx) {
+	start := time.Now()
+
+	source, ok := c.UserValue("address").(string)
+	if !ok || source == "" {
+		c.Error("Bad Request", fasthttp.StatusBadRequest)
+		return
+	}
+	block, err := strconv.Atoi(string(c.QueryArgs().Peek("block")))
+	if err != nil {
+		c.Error("Invalid block parameter", fasthttp.StatusBadRequest)
+		return
+	}
+	depth := TAINT_DEFAULT_DEPTH
+	if depthStr := string(c.QueryArgs().Peek("depth")); depthStr != "" {
+		depth, err = strconv.Atoi(depthStr)
+		if err != nil {
+			c.Error("Invalid depth parameter", fasthttp.StatusBadRequest)
+			return
+		}
+	}
+	var toBlock int
+	if toBlockStr := string(c.QueryArgs().Peek("toBlock")); toBlockStr != "" {
+		toBlock, err = strconv.Atoi(toBlockStr)
+		if err != nil {
+			c.Error("Invalid toBlock parameter", fasthttp.StatusBadRequest)
+			return
+		}
+	}
+	model := string(c.QueryArgs().Peek("model"))
+	if model == "" {
+		model = taint.MODEL_HAIRCUT
+	}
+
+	ctx, cancel, err := h.requestContext(c, string(c.QueryArgs().Peek("timeout")))
+	if err != nil {
+		c.Error("Invalid timeout parameter", fasthttp.StatusBadRequest)
+		return
+	}
+	defer cancel()
+
+	query := traverser.Query{
+		Roots:          []string{source},
+		Depth:          depth,
+		FromBlock:      block,
+		ToBlock:        toBlock,
+		GraphSizeLimit: h.cfg.Api.GraphSizeLimit,
+		Fetch:          h.fetchOptions(),
+	}
+	result, graph, err := taint.Analyze(ctx, query, model, h.redis)
+	if errors.Is(err, traverser.ErrInvalidQuery) || errors.Is(err, taint.ErrUnknownModel) {
+		c.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Err(err).Msgf("taint analysis of %s failed", source)
+		c.Error("Error analysing taint", errorStatus(err))
+		return
+	}
+	h.logCacheStats()
+
+	blocks := []int{}
+	for hash := range result.Txs {
+		blocks = append(blocks, (*graph.Txs)[hash].Block)
+	}
+	times := h.blockTimes(c, blocks)
+
+	edges := []schemas.TaintEdge{}
+	for hash, tainted := range result.Txs {
+		tx := (*graph.Txs)[hash]
+		edges = append(edges, schemas.TaintEdge{
+			Id:             hash,
+			From:           tx.From,
+			To:             tx.To,
+			Block:          tx.Block,
+			Timestamp:      times[tx.Block],
+			Tainted:        tainted,
+			FlowByCurrency: tx.FlowByCurrency,
+		})
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].Block != edges[j].Block {
+			return edges[i].Block < edges[j].Block
+		}
+		return edges[i].Id < edges[j].Id
+	})
+
+	addrNodes, err := utils.FetchAddresses(c, append([]string{source}, sortedKeys(result.Addresses)...), h.redis)
+	if err != nil {
+		c.Error("Error fetching address", errorStatus(err))
+		return
+	}
+	nodes := []schemas.TaintNode{}
+	for _, node := range addrNodes {
+		addr := node.Id
+		node.Picked = addr == source
+		taintNode := schemas.TaintNode{Node: node, Taint: map[string]schemas.AddressTaint{}}
+		for currency, t := range result.Addresses[addr] {
+			taintNode.Taint[currency] = schemas.AddressTaint{Received: t.Received, Held: t.Held}
+		}
+		nodes = append(nodes, taintNode)
+	}
+
+	data := schemas.TaintResponse{
+		Model:     result.Model,
+		Source:    source,
+		Block:     block,
+		Nodes:     nodes,
+		Edges:     edges,
+		Truncated: graph.Truncated,
+	}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		c.Error("Error encoding JSON", fasthttp.StatusInternalServerError)
+		return
+	}
+	c.Write(jsonData)
+
+	c.SetContentType("application/json")
+	c.Response.Header.Set("Access-Control-Allow-Origin", "*")
+	c.Response.Header.Set("Access-Control-Allow-Methods", "GET")
+	c.Response.Header.Set("Access-Control-Allow-Headers", "Content-Type")
+	c.Response.SetStatusCode(fasthttp.StatusOK)
+
+	log.Info().Msgf("TaintHandler %s in %s", source, time.Since(start))
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
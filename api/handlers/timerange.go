@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"chain-traverser/internal/storage"
+)
+
+// the time range of a request is invalid or holds no indexed block
+var ErrTimeRange = errors.New("invalid time range")
+
+// parseTime accepts RFC 3339 or unix seconds
+func parseTime(value string) (int64, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return seconds, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q is neither RFC 3339 nor unix time", ErrTimeRange, value)
+	}
+	return t.Unix(), nil
+}
+
+// blockRange narrows fromBlock..toBlock to the blocks mined between fromTime and toTime,
+// empty times leave the range as is
+func (h *Handler) blockRange(ctx context.Context, fromBlock int, toBlock int, fromTime string, toTime string) (int, int, error) {
+	if fromTime != "" {
+		timestamp, err := parseTime(fromTime)
+		if err != nil {
+			return 0, 0, err
+		}
+		block, err := h.redis.BlockAtTime(ctx, timestamp, false)
+		if errors.Is(err, storage.ErrNotFound) {
+			return 0, 0, fmt.Errorf("%w: no block indexed after %s", ErrTimeRange, fromTime)
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		fromBlock = max(fromBlock, int(block))
+	}
+	if toTime != "" {
+		timestamp, err := parseTime(toTime)
+		if err != nil {
+			return 0, 0, err
+		}
+		block, err := h.redis.BlockAtTime(ctx, timestamp, true)
+		if errors.Is(err, storage.ErrNotFound) {
+			return 0, 0, fmt.Errorf("%w: no block indexed before %s", ErrTimeRange, toTime)
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		toBlock = min(toBlock, int(block))
+	}
+	if fromBlock > toBlock {
+		return 0, 0, fmt.Errorf("%w: no block between %s and %s", ErrTimeRange, fromTime, toTime)
+	}
+	return fromBlock, toBlock, nil
+}
+
+// blockTimes returns unix timestamps of blocks, blocks indexed before timestamps
+// were kept are left out
+func (h *Handler) blockTimes(ctx context.Context, blocks []int) map[int]int64 {
+	numbers := []string{}
+	seen := make(map[int]bool)
+	for _, block := range blocks {
+		if !seen[block] {
+			seen[block] = true
+			numbers = append(numbers, strconv.Itoa(block))
+		}
+	}
+	times := make(map[int]int64, len(numbers))
+	if len(numbers) == 0 {
+		return times
+	}
+	found, err := h.redis.GetBlockTimes(ctx, numbers)
+	if err != nil {
+		log.Err(err).Msgf("Cant get timestamps of %d blocks", len(numbers))
+		return times
+	}
+	for number, timestamp := range found {
+		block, _ := strconv.Atoi(number)
+		times[block] = timestamp
+	}
+	return times
+}
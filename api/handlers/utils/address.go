@@ -2,9 +2,9 @@ package utils
 
 import (
 	"chain-traverser/api/handlers/schemas"
+	"chain-traverser/internal/storage"
 	"chain-traverser/internal/storage/redis"
-
-	"github.com/rs/zerolog/log"
+	"context"
 )
 
 func AddressLabel(address string, redis *redis.RedisClient) string {
@@ -12,12 +12,31 @@ func AddressLabel(address string, redis *redis.RedisClient) string {
 	return address[len(address)-8:]
 }
 
-func FetchAddress(address string, redis *redis.RedisClient) schemas.Node {
-	cnt, err := redis.GetAddressTxNumber(&address)
-	if err != nil {
-		log.Err(err).Msgf("GetAddressTxNumber failed | address: %s", address)
+// addresses whose counters and labels are read per round trip
+const FETCH_ADDRESSES_BATCH = 1000
+
+// FetchAddresses reads counters and labels of addresses in batches,
+// nodes are returned in the order of addresses
+func FetchAddresses(ctx context.Context, addresses []string, redis *redis.RedisClient) ([]schemas.Node, error) {
+	nodes := make([]schemas.Node, 0, len(addresses))
+	for i := 0; i < len(addresses); i += FETCH_ADDRESSES_BATCH {
+		batch := addresses[i:min(i+FETCH_ADDRESSES_BATCH, len(addresses))]
+		counters, err := redis.GetAddressTxNumbers(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		labels, err := redis.GetAddressesLabels(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		for _, address := range batch {
+			nodes = append(nodes, newNode(address, counters[address], labels[address]))
+		}
 	}
-	labels, _ := redis.GetAddressLabels(&address)
+	return nodes, nil
+}
+
+func newNode(address string, cnt int64, labels *storage.Labels) schemas.Node {
 	var primeLabel string
 	var adType string
 	if labels != nil {
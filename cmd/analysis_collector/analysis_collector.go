@@ -9,6 +9,7 @@ import (
 	"strings"
 
 	"chain-traverser/internal/blockchain/eth"
+	"chain-traverser/internal/config"
 	"chain-traverser/internal/storage/redis"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -120,7 +121,7 @@ func fetchWallets(
 		log.Fatal().Err(err).Msg("error fetching finish block")
 	}
 	finishBlockTime := finishBlock.Time()
-	//blockEthPriceUsd, err := eth.GetTokenPrice(finishBlockTime, redis, eth.ETH)
+	//blockEthPriceUsd, err := eth.GetTokenPrice(ctx, finishBlockTime, redis, eth.ETH)
 	if err != nil {
 		log.Fatal().Err(err).Msg("error getting eth price for block")
 	}
@@ -130,7 +131,7 @@ func fetchWallets(
 		blockNumberStr := strconv.Itoa(block_number)
 		log.Info().Msgf("block: %s", blockNumberStr)
 
-		block, err := redis.GetBlock(&blockNumberStr)
+		block, err := redis.GetBlock(ctx, &blockNumberStr)
 		if err != nil {
 			log.Fatal().Err(err).Msg("error getting block")
 		}
@@ -166,7 +167,7 @@ func fetchWallets(
 					log.Fatal().Err(err).Msg("error converting ethUsdOnDay to int")
 				}
 
-				tokenPrice, err := eth.GetTokenPrice(finishBlockTime, redis, ticker)
+				tokenPrice, err := eth.GetTokenPrice(ctx, finishBlockTime, redis, ticker)
 				if err != nil {
 					log.Error().Err(err).Msgf("failed to get token price %s", ticker)
 					continue
@@ -266,8 +267,17 @@ func updateRef(ref NormalizetionRef, w Wallet) NormalizetionRef {
 func main() {
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 
-	redis := redis.NewClient()
-	client, err := eth.NewEthClient()
+	cfg, err := config.NewConfig()
+	if err != nil {
+		log.Err(err).Msg("error reading config")
+		return
+	}
+	redis, err := redis.NewClient(ctx, &cfg.Redis)
+	if err != nil {
+		log.Err(err).Msg("error connecting to redis")
+		return
+	}
+	client, err := eth.NewEthClient(&cfg.Eth)
 	if err != nil {
 		log.Err(err).Msg("error connecting to Ethereum node")
 		return
@@ -3,7 +3,6 @@ package main
 import (
 	"chain-traverser/internal/blockchain/eth"
 	"chain-traverser/internal/storage/redis"
-	"context"
 	"math"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
@@ -22,7 +21,7 @@ func erc20Balance(address common.Address, client *eth.EthClient, redis *redis.Re
 	if err != nil {
 		log.Fatal().Msgf("failed to fetch balance address %s", address.String())
 	}
-	price, err := eth.GetTokenPrice(blockTime, redis, token.Ticker)
+	price, err := eth.GetTokenPrice(ctx, blockTime, redis, token.Ticker)
 	if err != nil {
 		log.Fatal().Msgf("error getting token price %s", token.Ticker)
 	}
@@ -36,11 +35,11 @@ func erc20Balance(address common.Address, client *eth.EthClient, redis *redis.Re
 
 func ethUsdBalance(address common.Address, client *eth.EthClient, redis *redis.RedisClient, blockTime uint64) float64 {
 	// return usd balance
-	ethUsdRate, err := eth.GetTokenPrice(blockTime, redis, eth.ETH)
+	ethUsdRate, err := eth.GetTokenPrice(ctx, blockTime, redis, eth.ETH)
 	if err != nil {
 		log.Fatal().Msg("error getting eth price")
 	}
-	ethBalance, err := client.Client.BalanceAt(context.Background(), address, nil)
+	ethBalance, err := client.Client.BalanceAt(ctx, address, nil)
 	if err != nil {
 		log.Fatal().Err(err).Msg("error getting eth balance")
 	}
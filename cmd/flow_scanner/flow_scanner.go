@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"chain-traverser/internal/analysis"
+	"chain-traverser/internal/config"
+	"chain-traverser/internal/storage/redis"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// usage:
+//
+//	flow_scanner -last 7200                    score addresses active in the last 7200 indexed blocks
+//	flow_scanner -from 19050000 -to 19057200   score addresses active in a block range
+func main() {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+
+	cfg, err := config.NewConfig()
+	if err != nil {
+		log.Err(err).Msg("error reading config")
+		return
+	}
+
+	fromBlock := flag.Int64("from", 0, "first block")
+	toBlock := flag.Int64("to", 0, "last block")
+	last := flag.Int64("last", 0, "scan this many blocks up to the last indexed one")
+	batchSize := flag.Int("batch", 100, "blocks per read")
+	minScore := flag.Float64("min-score", 0.5, "lowest pattern score reported")
+	minCounterparties := flag.Int("min-counterparties", analysis.DEFAULT_SMURF_MIN_COUNTERPARTIES, "counterparties a pattern needs")
+	freshMaxTxs := flag.Int64("fresh-max-txs", analysis.DEFAULT_FRESH_MAX_TXS, "most txs of a fresh address")
+	out := flag.String("out", "", "CSV file, flow_patterns_<time>.csv by default")
+	flag.Parse()
+
+	ctx := context.Background()
+
+	client, err := redis.NewClient(ctx, &cfg.Redis)
+	if err != nil {
+		log.Fatal().Err(err).Msg("error connecting to redis")
+	}
+	defer client.Close()
+
+	if *last > 0 {
+		lastBlock, err := client.GetLastBlockNumber(ctx)
+		if err != nil {
+			log.Fatal().Err(err).Msg("error reading last indexed block")
+		}
+		*toBlock = *lastBlock
+		*fromBlock = *lastBlock - *last + 1
+	}
+	if *fromBlock <= 0 || *toBlock < *fromBlock || *batchSize <= 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	start := time.Now()
+	opts := analysis.SmurfOptions{MinCounterparties: *minCounterparties, FreshMaxTxs: *freshMaxTxs}
+	flagged, err := analysis.ScanFlows(ctx, *fromBlock, *toBlock, *batchSize, opts, *minScore, client)
+	if err != nil {
+		log.Fatal().Err(err).Msg("scan failed")
+	}
+
+	if *out == "" {
+		*out = fmt.Sprintf("flow_patterns_%s.csv", time.Now().Format("20060102150405"))
+	}
+	if err := writeCsv(*out, flagged); err != nil {
+		log.Fatal().Err(err).Msg("error writing results")
+	}
+	log.Info().Msgf("flagged %d addresses of blocks %d..%d in %s, written to %s", len(flagged), *fromBlock, *toBlock, time.Since(start), *out)
+}
+
+func writeCsv(path string, flagged []analysis.FlowScores) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	header := []string{"Address", "FanIn", "FanOut", "Structuring", "Senders", "Recipients", "InUsd", "OutUsd", "Source", "Consolidation", "FreshSenders", "FreshRecipients"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, s := range flagged {
+		record := []string{
+			s.Address,
+			fmt.Sprintf("%.4f", s.FanIn),
+			fmt.Sprintf("%.4f", s.FanOut),
+			fmt.Sprintf("%.4f", s.Structuring),
+			fmt.Sprintf("%d", s.Senders),
+			fmt.Sprintf("%d", s.Recipients),
+			s.InUsd.StringFixed(2),
+			s.OutUsd.StringFixed(2),
+			s.Source,
+			s.Consolidation,
+			// participants are space separated
+			strings.Join(s.FreshSenders, " "),
+			strings.Join(s.FreshRecipients, " "),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: flow_scanner -last <blocks> | -from <block> -to <block>\n")
+		flag.PrintDefaults()
+	}
+}
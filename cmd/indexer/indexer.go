@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/big"
 	"os"
@@ -11,6 +12,7 @@ import (
 
 	"chain-traverser/internal/blockchain/eth"
 	"chain-traverser/internal/config"
+	"chain-traverser/internal/storage"
 	"chain-traverser/internal/storage/redis"
 
 	"github.com/ethereum/go-ethereum/core/types"
@@ -27,8 +29,11 @@ type Indexer struct {
 	cfg    *config.Config
 }
 
-func NewIndexer(cfg *config.Config) (*Indexer, error) {
-	redis := redis.NewClient(&cfg.Redis)
+func NewIndexer(ctx context.Context, cfg *config.Config) (*Indexer, error) {
+	redis, err := redis.NewClient(ctx, &cfg.Redis)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to redis: %w", err)
+	}
 	client, err := eth.NewEthClient(&cfg.Eth)
 	if err != nil {
 		return nil, fmt.Errorf("error connecting to Ethereum node: %w", err)
@@ -41,19 +46,27 @@ func NewIndexer(cfg *config.Config) (*Indexer, error) {
 }
 
 // if address has more then 10k transactions and no labels, ask to enrich
-func (i *Indexer) askToEnrichAddress(address string) {
-	cnt, _ := i.redis.GetAddressTxNumber(&address)
-	if cnt < 10_000 {
+func (i *Indexer) askToEnrichAddress(ctx context.Context, address string) {
+	cnt, err := i.redis.GetAddressTxNumber(ctx, &address)
+	if err != nil || cnt < 10_000 {
 		return
 	}
-	labels, _ := i.redis.GetAddressLabels(&address)
-	if labels != nil {
+	_, err = i.redis.GetAddressLabels(ctx, &address)
+	if !errors.Is(err, storage.ErrNotFound) {
+		// already labeled, or we can't tell
 		return
 	}
-	i.redis.SendAddress(address)
+	i.redis.SendAddress(ctx, address)
 }
 
-func (i *Indexer) handleBlock(blockNumber *big.Int, ctx context.Context) *map[string]int64 {
+// everything indexed from a single block, committed at once
+type indexedBlock struct {
+	blob      string
+	timestamp uint64
+	transMap  map[string]int64
+}
+
+func (i *Indexer) handleBlock(blockNumber *big.Int, ctx context.Context) *indexedBlock {
 	block, err := i.client.Client.BlockByNumber(ctx, blockNumber)
 	if err != nil {
 		log.Err(err).Msgf("fetch block %d by number error", blockNumber)
@@ -63,7 +76,7 @@ func (i *Indexer) handleBlock(blockNumber *big.Int, ctx context.Context) *map[st
 	}
 	transMap := make(map[string]int64)
 	blockTime := block.Time()
-	blockEthPriceUsd, err := eth.GetTokenPrice(blockTime, i.redis, eth.ETH)
+	blockEthPriceUsd, err := eth.GetTokenPrice(ctx, blockTime, i.redis, eth.ETH)
 	if err != nil || blockEthPriceUsd == nil {
 		log.Err(err).Msgf("error getting price for block: %d", blockNumber)
 		return nil
@@ -98,13 +111,13 @@ func (i *Indexer) handleBlock(blockNumber *big.Int, ctx context.Context) *map[st
 				usdOnDay = usd.RoundBank(2)
 				// set erc20 values to 0
 				blob += fmt.Sprintf("%s;%s;%s;%s;%s;%s;%s;%s\n", fromHash, tx.Hash().Hex(), toHash, value, usdOnDay.String(), "nil", "0", "0")
-				i.askToEnrichAddress(fromHash)
-				i.askToEnrichAddress(toHash)
+				i.askToEnrichAddress(ctx, fromHash)
+				i.askToEnrichAddress(ctx, toHash)
 			} else {
 				// if valie is 0, check if it's erc20
 				erc20tx, err := i.client.HandleERC20(*tx)
 				if err == nil && erc20tx != nil {
-					tokenPrice, err := eth.GetTokenPrice(blockTime, i.redis, erc20tx.Ticker)
+					tokenPrice, err := eth.GetTokenPrice(ctx, blockTime, i.redis, erc20tx.Ticker)
 					if err != nil || tokenPrice == nil {
 						continue
 					}
@@ -112,34 +125,27 @@ func (i *Indexer) handleBlock(blockNumber *big.Int, ctx context.Context) *map[st
 					usdOnDay = usd.RoundBank(2)
 
 					blob += fmt.Sprintf("%s;%s;%s;%s;%s;%s;%s;%s\n", fromHash, tx.Hash().Hex(), erc20tx.To, "0", "0", erc20tx.Ticker, erc20tx.Value, usdOnDay)
-					i.askToEnrichAddress(fromHash)
-					i.askToEnrichAddress(toHash)
+					i.askToEnrichAddress(ctx, fromHash)
+					i.askToEnrichAddress(ctx, toHash)
 				}
 
 			}
 
 		}
 	}
-	go i.redis.AddBlock(blockNumber, &blob)
-	return &transMap
+	return &indexedBlock{blob: blob, timestamp: blockTime, transMap: transMap}
 }
 
-func (i *Indexer) getNextBlockNumber() (*big.Int, error) {
-	blockNumber, err := i.redis.GetLastBlockNumber()
-	if err != nil {
-		return nil, err
+func (i *Indexer) getNextBlockNumber(ctx context.Context) (*big.Int, error) {
+	blockNumber, err := i.redis.GetLastBlockNumber(ctx)
+	if errors.Is(err, storage.ErrNotFound) {
+		// nothing indexed yet
+		return big.NewInt(i.cfg.Indexer.StartBlockNumber), nil
 	}
-
-	if err != nil && err.Error() != "redis: nil" {
+	if err != nil {
 		return nil, err
 	}
-	var blockNumberToHandle *big.Int
-	if blockNumber == nil {
-		blockNumberToHandle = big.NewInt(i.cfg.Indexer.StartBlockNumber)
-	} else {
-		blockNumberToHandle = big.NewInt(*blockNumber + 1)
-	}
-	return blockNumberToHandle, nil
+	return big.NewInt(*blockNumber + 1), nil
 
 }
 
@@ -152,7 +158,7 @@ func (i *Indexer) processBlocks(ctx context.Context) error {
 		case <-ctx.Done():
 			return ctx.Err()
 		default:
-			blockNumber, err := i.getNextBlockNumber()
+			blockNumber, err := i.getNextBlockNumber(ctx)
 			if err != nil {
 				log.Err(err).Msg("error getting next block number")
 				time.Sleep(5 * time.Second)
@@ -166,15 +172,15 @@ func (i *Indexer) processBlocks(ctx context.Context) error {
 				continue
 			}
 
-			go i.redis.UpdateCounters(*result)
-			go i.redis.AppendBlockNumbers(*result, blockNumber)
-
-			err = i.redis.UpdateLastBlockNumber(blockNumber)
+			committed, err := i.redis.CommitBlock(ctx, blockNumber, result.timestamp, &result.blob, result.transMap)
 			if err != nil {
-				log.Err(err).Msg("error during updating next block number")
+				log.Err(err).Msg("error during committing block")
 				time.Sleep(5 * time.Second)
 				continue
 			}
+			if !committed {
+				log.Warn().Msgf("block %s already committed, skip", blockNumber)
+			}
 
 			blockCount++
 			if blockCount%100 == 0 {
@@ -201,13 +207,14 @@ func main() {
 		return
 	}
 
-	indexer, err := NewIndexer(cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	indexer, err := NewIndexer(ctx, cfg)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to create indexer")
 	}
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	defer indexer.redis.Close()
 
 	go func() {
 		stop := make(chan os.Signal, 1)
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"chain-traverser/internal/config"
+	"chain-traverser/internal/storage/redis"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// usage:
+//
+//	migrator -from 1 -to 2      build keyspace v2 from v1, can be re-run to catch up
+//	migrator -switch 2          atomically point readers (REDIS_DB_VERSION="") to v2
+func main() {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+
+	cfg, err := config.NewConfig()
+	if err != nil {
+		log.Err(err).Msg("error reading config")
+		return
+	}
+
+	from := flag.String("from", "", "source keyspace version")
+	to := flag.String("to", "", "target keyspace version")
+	switchTo := flag.String("switch", "", "version to switch readers to")
+	startBlock := flag.Int64("start", cfg.Indexer.StartBlockNumber, "first block to migrate")
+	batchSize := flag.Int("batch", 100, "blocks per transaction")
+	flag.Parse()
+
+	ctx := context.Background()
+
+	client, err := redis.NewClient(ctx, &cfg.Redis)
+	if err != nil {
+		log.Fatal().Err(err).Msg("error connecting to redis")
+	}
+
+	if *switchTo != "" {
+		active, err := client.GetActiveVersion(ctx)
+		if err != nil {
+			log.Fatal().Err(err).Msg("error reading active version")
+		}
+		if err := client.SetActiveVersion(ctx, *switchTo); err != nil {
+			log.Fatal().Err(err).Msg("error switching version")
+		}
+		log.Info().Msgf("switched active version %q -> %q", active, *switchTo)
+		return
+	}
+
+	if *from == "" || *to == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	start := time.Now()
+	copied, lastBlock, err := client.CopyKeys(ctx, *from, *to)
+	if err != nil {
+		log.Fatal().Err(err).Msg("error copying keys")
+	}
+	log.Info().Msgf("copied %d keys up to block %d in %s", copied, lastBlock, time.Since(start))
+
+	migrated, err := client.MigrateBlocks(ctx, redis.MigrateOptions{
+		From:          *from,
+		To:            *to,
+		StartBlock:    *startBlock,
+		LastBlock:     lastBlock,
+		BatchSize:     *batchSize,
+		ReencodeBlock: reencodeBlock,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msgf("migration stopped after %d blocks", migrated)
+	}
+	log.Info().Msgf("migrated %d blocks in %s", migrated, time.Since(start))
+}
+
+// the blob is a list of "from;txHash;to;value;usd;erc20;erc20Value;erc20Usd" lines,
+// see cmd/indexer. Drop empty and malformed lines.
+func reencodeBlock(blockNumber int64, blob string) (string, error) {
+	var sb strings.Builder
+	for _, tx := range strings.Split(blob, "\n") {
+		if tx == "" {
+			continue
+		}
+		if len(strings.Split(tx, ";")) != 8 {
+			log.Warn().Msgf("drop malformed tx in block %d: %s", blockNumber, tx)
+			continue
+		}
+		sb.WriteString(tx)
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
+}
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: migrator -from <version> -to <version> | -switch <version>\n")
+		flag.PrintDefaults()
+	}
+}
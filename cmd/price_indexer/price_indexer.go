@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"os"
 	"time"
 
@@ -14,7 +15,7 @@ import (
 
 const WORKER_TIMEOUT = 1 * time.Hour
 
-func updatePriceData(redis *redis.RedisClient, priceClient *ccClient) error {
+func updatePriceData(ctx context.Context, redis *redis.RedisClient, priceClient *ccClient) error {
 	start := time.Now()
 
 	log.Info().Msg("Start pricing update...")
@@ -27,7 +28,7 @@ func updatePriceData(redis *redis.RedisClient, priceClient *ccClient) error {
 		}
 
 		for _, data := range *priceData {
-			err = redis.SetPriceData(data.Timestamp, data.PriceUSD, currency)
+			err = redis.SetPriceData(ctx, data.Timestamp, data.PriceUSD, currency)
 			if err != nil {
 				return err
 			}
@@ -45,13 +46,18 @@ func main() {
 		log.Err(cErr).Msg("Error loading config")
 		return
 	}
-	redis := redis.NewClient(&cfg.Redis)
+	ctx := context.Background()
+	redis, err := redis.NewClient(ctx, &cfg.Redis)
+	if err != nil {
+		log.Err(err).Msg("Error connecting to redis")
+		return
+	}
 	ccClient := NewCCClient(cfg.CryptoCompare.ApiKey)
 	// stop := make(chan os.Signal, 1)
 	// signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
 	for {
-		err := updatePriceData(redis, ccClient)
+		err := updatePriceData(ctx, redis, ccClient)
 		if err != nil {
 			log.Err(err).Msg("Error updating pricing data")
 		}
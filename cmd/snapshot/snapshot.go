@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"chain-traverser/internal/blockchain/eth"
+	"chain-traverser/internal/config"
+	"chain-traverser/internal/storage/redis"
+	"chain-traverser/internal/storage/snapshot"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// usage:
+//
+//	snapshot export -from 19050000 -to 19051000 -out case.snap.gz
+//	snapshot import -in case.snap.gz
+func main() {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	cfg, err := config.NewConfig()
+	if err != nil {
+		log.Err(err).Msg("error reading config")
+		return
+	}
+	ctx := context.Background()
+
+	switch os.Args[1] {
+	case "export":
+		flags := flag.NewFlagSet("export", flag.ExitOnError)
+		fromBlock := flags.Int64("from", 0, "first block")
+		toBlock := flags.Int64("to", 0, "last block")
+		out := flags.String("out", "", "snapshot file")
+		flags.Parse(os.Args[2:])
+		if *out == "" || *fromBlock <= 0 || *toBlock < *fromBlock {
+			flags.Usage()
+			os.Exit(2)
+		}
+		if err := export(ctx, cfg, *fromBlock, *toBlock, *out); err != nil {
+			log.Fatal().Err(err).Msg("export failed")
+		}
+	case "import":
+		flags := flag.NewFlagSet("import", flag.ExitOnError)
+		in := flags.String("in", "", "snapshot file")
+		flags.Parse(os.Args[2:])
+		if *in == "" {
+			flags.Usage()
+			os.Exit(2)
+		}
+		if err := load(ctx, cfg, *in); err != nil {
+			log.Fatal().Err(err).Msg("import failed")
+		}
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: snapshot export -from <block> -to <block> -out <file> | import -in <file>")
+	os.Exit(2)
+}
+
+func export(ctx context.Context, cfg *config.Config, fromBlock int64, toBlock int64, path string) error {
+	start := time.Now()
+	client, err := redis.NewClient(ctx, &cfg.Redis)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w, err := snapshot.NewWriter(file, snapshot.Header{
+		Version:   client.Version(),
+		FromBlock: fromBlock,
+		ToBlock:   toBlock,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	// the indexer counts token contracts under their checksummed address
+	contracts := make([]string, len(eth.CONTRACTS_TO_TRACK))
+	for i, contract := range eth.CONTRACTS_TO_TRACK {
+		contracts[i] = common.HexToAddress(contract).Hex()
+	}
+	if err := client.ExportSnapshot(ctx, w, fromBlock, toBlock, contracts); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	log.Info().Msgf("exported %d records to %s in %s", w.Count(), path, time.Since(start))
+	return file.Close()
+}
+
+func load(ctx context.Context, cfg *config.Config, path string) error {
+	start := time.Now()
+
+	// the checksum is in the trailer, verify the whole file before writing anything
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	header, err := snapshot.Verify(file)
+	file.Close()
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("verified snapshot of blocks %d..%d (v%s, %s)", header.FromBlock, header.ToBlock, header.Version, header.CreatedAt)
+
+	client, err := redis.NewClient(ctx, &cfg.Redis)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	file, err = os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, imported, err := client.ImportSnapshot(ctx, file)
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("imported %d records into v%s in %s", imported, client.Version(), time.Since(start))
+	return nil
+}
@@ -3,7 +3,6 @@ module chain-traverser
 go 1.22.0
 
 require (
-	github.com/dominikbraun/graph v0.23.0
 	github.com/ethereum/go-ethereum v1.13.12
 	github.com/fasthttp/router v1.4.22
 	github.com/muesli/clusters v0.0.0-20200529215643-2700303c1762
@@ -0,0 +1,118 @@
+// analysis scores the addresses of a collected graph: centrality, connected
+// components and communities, so that clients can size and colour nodes.
+package analysis
+
+import (
+	"chain-traverser/internal/traverser"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const (
+	// edges weigh the USD amount they moved
+	WEIGHT_USD = "usd"
+	// edges weigh the number of their txs
+	WEIGHT_COUNT = "count"
+)
+
+var ErrUnknownWeight = errors.New("unknown edge weight")
+
+// Scores holds every metric per address of the graph
+type Scores struct {
+	PageRank    map[string]float64
+	Betweenness map[string]float64
+	// component ids, dense from 0 in the order of the smallest address of a component
+	Weak      map[string]int
+	Strong    map[string]int
+	Community map[string]int
+	// modularity of the communities
+	Modularity float64
+}
+
+// digraph is a weighted directed graph over dense node indexes,
+// parallel txs between two addresses are merged into one edge
+type digraph struct {
+	nodes []string
+	out   []map[int]float64
+}
+
+func newDigraph(graph *traverser.Graph, weight string) (*digraph, error) {
+	if weight != WEIGHT_USD && weight != WEIGHT_COUNT {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownWeight, weight)
+	}
+	seen := make(map[string]bool)
+	for _, tx := range *graph.Txs {
+		seen[tx.From] = true
+		seen[tx.To] = true
+	}
+	g := &digraph{nodes: make([]string, 0, len(seen))}
+	for node := range seen {
+		g.nodes = append(g.nodes, node)
+	}
+	// indexes follow address order so results don't depend on map iteration
+	sort.Strings(g.nodes)
+	index := make(map[string]int, len(g.nodes))
+	for i, node := range g.nodes {
+		index[node] = i
+	}
+	g.out = make([]map[int]float64, len(g.nodes))
+	for i := range g.nodes {
+		g.out[i] = make(map[int]float64)
+	}
+	for _, tx := range *graph.Txs {
+		if tx.From == tx.To {
+			continue
+		}
+		w := 1.0
+		if weight == WEIGHT_USD {
+			w = tx.TotalUsdFlow.InexactFloat64()
+		}
+		from, to := index[tx.From], index[tx.To]
+		g.out[from][to] += w
+	}
+	return g, nil
+}
+
+// sortedKeys returns neighbour indexes in increasing order
+func sortedKeys(m map[int]float64) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+func (g *digraph) byAddress(values []float64) map[string]float64 {
+	result := make(map[string]float64, len(values))
+	for i, v := range values {
+		result[g.nodes[i]] = v
+	}
+	return result
+}
+
+func (g *digraph) idsByAddress(ids []int) map[string]int {
+	result := make(map[string]int, len(ids))
+	for i, id := range ids {
+		result[g.nodes[i]] = id
+	}
+	return result
+}
+
+// Analyze computes all the metrics over the txs of the graph
+func Analyze(graph *traverser.Graph, weight string) (*Scores, error) {
+	g, err := newDigraph(graph, weight)
+	if err != nil {
+		return nil, err
+	}
+	communities, modularity := g.louvain()
+	return &Scores{
+		PageRank:    g.byAddress(g.pageRank()),
+		Betweenness: g.byAddress(g.betweenness()),
+		Weak:        g.idsByAddress(g.weakComponents()),
+		Strong:      g.idsByAddress(g.strongComponents()),
+		Community:   g.idsByAddress(communities),
+		Modularity:  modularity,
+	}, nil
+}
@@ -0,0 +1,180 @@
+package analysis
+
+import (
+	"chain-traverser/internal/traverser"
+	"errors"
+	"maps"
+	"math"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+// transfer is a tx of usd dollars, its hash is "from>to"
+type transfer struct {
+	from string
+	to   string
+	usd  int64
+}
+
+func graphOf(transfers []transfer) *traverser.Graph {
+	txs := make(map[string]traverser.Tx)
+	for _, t := range transfers {
+		hash := t.from + ">" + t.to
+		txs[hash] = traverser.Tx{TxHash: hash, From: t.from, To: t.to, TotalUsdFlow: decimal.NewFromInt(t.usd)}
+	}
+	return &traverser.Graph{Addrs: &map[string]traverser.Addr{}, Txs: &txs}
+}
+
+var (
+	// leaves sending to a hub
+	star = []transfer{{"a", "h", 1}, {"b", "h", 1}, {"c", "h", 1}, {"d", "h", 1}}
+	// a 3-cycle with a tail
+	cycle = []transfer{{"a", "b", 1}, {"b", "c", 1}, {"c", "a", 1}, {"c", "d", 1}}
+	// two triangles joined by the c-d bridge
+	cliques = []transfer{{"a", "b", 1}, {"b", "c", 1}, {"c", "a", 1}, {"d", "e", 1}, {"e", "f", 1}, {"f", "d", 1}, {"c", "d", 1}}
+)
+
+func near(a float64, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func analyze(t *testing.T, transfers []transfer, weight string) *Scores {
+	t.Helper()
+	scores, err := Analyze(graphOf(transfers), weight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return scores
+}
+
+func TestPageRank(t *testing.T) {
+	scores := analyze(t, star, WEIGHT_COUNT)
+	sum := 0.0
+	for _, rank := range scores.PageRank {
+		sum += rank
+	}
+	if !near(sum, 1) {
+		t.Errorf("ranks sum to %f, want 1", sum)
+	}
+	for _, leaf := range []string{"b", "c", "d"} {
+		if !near(scores.PageRank[leaf], scores.PageRank["a"]) {
+			t.Errorf("leaf %s ranks %f, leaf a %f", leaf, scores.PageRank[leaf], scores.PageRank["a"])
+		}
+	}
+	if scores.PageRank["h"] <= scores.PageRank["a"] {
+		t.Errorf("hub ranks %f, not above leaves %f", scores.PageRank["h"], scores.PageRank["a"])
+	}
+
+	// rank follows the USD amount
+	scores = analyze(t, []transfer{{"s", "a", 90}, {"s", "b", 10}}, WEIGHT_USD)
+	if scores.PageRank["a"] <= scores.PageRank["b"] {
+		t.Errorf("a ranks %f, not above b %f", scores.PageRank["a"], scores.PageRank["b"])
+	}
+}
+
+func TestBetweenness(t *testing.T) {
+	tests := []struct {
+		name      string
+		transfers []transfer
+		want      map[string]float64
+	}{
+		{
+			name:      "star",
+			transfers: star,
+			want:      map[string]float64{"a": 0, "b": 0, "c": 0, "d": 0, "h": 0},
+		},
+		{
+			name:      "chain",
+			transfers: []transfer{{"a", "b", 1}, {"b", "c", 1}},
+			want:      map[string]float64{"a": 0, "b": 0.5, "c": 0},
+		},
+		{
+			// every address is on the path between the other two in one direction,
+			// c also on the paths from a and b to d
+			name:      "3-cycle",
+			transfers: cycle,
+			want:      map[string]float64{"a": 1.0 / 6, "b": 2.0 / 6, "c": 3.0 / 6, "d": 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scores := analyze(t, tt.transfers, WEIGHT_COUNT)
+			if !maps.EqualFunc(scores.Betweenness, tt.want, near) {
+				t.Errorf("betweenness %v, want %v", scores.Betweenness, tt.want)
+			}
+		})
+	}
+}
+
+func TestComponents(t *testing.T) {
+	tests := []struct {
+		name       string
+		transfers  []transfer
+		wantWeak   map[string]int
+		wantStrong map[string]int
+	}{
+		{
+			name:       "star",
+			transfers:  star,
+			wantWeak:   map[string]int{"a": 0, "b": 0, "c": 0, "d": 0, "h": 0},
+			wantStrong: map[string]int{"a": 0, "b": 1, "c": 2, "d": 3, "h": 4},
+		},
+		{
+			name:       "3-cycle",
+			transfers:  cycle,
+			wantWeak:   map[string]int{"a": 0, "b": 0, "c": 0, "d": 0},
+			wantStrong: map[string]int{"a": 0, "b": 0, "c": 0, "d": 1},
+		},
+		{
+			name:       "disconnected",
+			transfers:  []transfer{{"a", "b", 1}, {"c", "d", 1}, {"d", "c", 1}},
+			wantWeak:   map[string]int{"a": 0, "b": 0, "c": 1, "d": 1},
+			wantStrong: map[string]int{"a": 0, "b": 1, "c": 2, "d": 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scores := analyze(t, tt.transfers, WEIGHT_COUNT)
+			if !maps.Equal(scores.Weak, tt.wantWeak) {
+				t.Errorf("weak %v, want %v", scores.Weak, tt.wantWeak)
+			}
+			if !maps.Equal(scores.Strong, tt.wantStrong) {
+				t.Errorf("strong %v, want %v", scores.Strong, tt.wantStrong)
+			}
+		})
+	}
+}
+
+func TestLouvain(t *testing.T) {
+	scores := analyze(t, cliques, WEIGHT_COUNT)
+	want := map[string]int{"a": 0, "b": 0, "c": 0, "d": 1, "e": 1, "f": 1}
+	if !maps.Equal(scores.Community, want) {
+		t.Errorf("communities %v, want %v", scores.Community, want)
+	}
+	// 2 * (3/7 - (7/14)^2)
+	if !near(scores.Modularity, 2*(3.0/7-0.25)) {
+		t.Errorf("modularity %f, want %f", scores.Modularity, 2*(3.0/7-0.25))
+	}
+
+	// a star is a single community, no split beats it
+	scores = analyze(t, star, WEIGHT_COUNT)
+	for addr, community := range scores.Community {
+		if community != 0 {
+			t.Errorf("%s in community %d, want 0", addr, community)
+		}
+	}
+}
+
+func TestAnalyzeEmpty(t *testing.T) {
+	scores := analyze(t, nil, WEIGHT_USD)
+	if len(scores.PageRank) != 0 || len(scores.Community) != 0 || scores.Modularity != 0 {
+		t.Errorf("scores of an empty graph %+v", scores)
+	}
+}
+
+func TestAnalyzeUnknownWeight(t *testing.T) {
+	if _, err := Analyze(graphOf(star), "fame"); !errors.Is(err, ErrUnknownWeight) {
+		t.Errorf("err %v, want %v", err, ErrUnknownWeight)
+	}
+}
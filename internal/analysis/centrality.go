@@ -0,0 +1,115 @@
+package analysis
+
+import "math"
+
+const (
+	PAGERANK_DAMPING    = 0.85
+	PAGERANK_ITERATIONS = 100
+	PAGERANK_TOLERANCE  = 1e-9
+)
+
+// pageRank follows edges proportionally to their weight, the rank of addresses
+// sending nothing is spread over the whole graph
+func (g *digraph) pageRank() []float64 {
+	n := len(g.nodes)
+	if n == 0 {
+		return nil
+	}
+	outWeight := make([]float64, n)
+	for i := range g.nodes {
+		for _, w := range g.out[i] {
+			outWeight[i] += w
+		}
+	}
+	rank := make([]float64, n)
+	for i := range rank {
+		rank[i] = 1 / float64(n)
+	}
+	for iteration := 0; iteration < PAGERANK_ITERATIONS; iteration++ {
+		dangling := 0.0
+		for i := range g.nodes {
+			if outWeight[i] <= 0 {
+				dangling += rank[i]
+			}
+		}
+		next := make([]float64, n)
+		base := (1-PAGERANK_DAMPING)/float64(n) + PAGERANK_DAMPING*dangling/float64(n)
+		for i := range next {
+			next[i] = base
+		}
+		for i := range g.nodes {
+			if outWeight[i] <= 0 {
+				continue
+			}
+			for _, j := range sortedKeys(g.out[i]) {
+				next[j] += PAGERANK_DAMPING * rank[i] * g.out[i][j] / outWeight[i]
+			}
+		}
+		delta := 0.0
+		for i := range rank {
+			delta += math.Abs(next[i] - rank[i])
+		}
+		rank = next
+		if delta < PAGERANK_TOLERANCE {
+			break
+		}
+	}
+	return rank
+}
+
+// betweenness is the share of shortest directed paths between other addresses
+// going through an address (Brandes). Paths are counted in hops, weights are ignored:
+// a larger amount doesn't make a route shorter.
+func (g *digraph) betweenness() []float64 {
+	n := len(g.nodes)
+	centrality := make([]float64, n)
+	neighbours := make([][]int, n)
+	for i := range g.nodes {
+		neighbours[i] = sortedKeys(g.out[i])
+	}
+
+	for s := 0; s < n; s++ {
+		stack := []int{}
+		predecessors := make([][]int, n)
+		sigma := make([]float64, n)
+		dist := make([]int, n)
+		for i := range dist {
+			dist[i] = -1
+		}
+		sigma[s] = 1
+		dist[s] = 0
+		queue := []int{s}
+		for len(queue) > 0 {
+			v := queue[0]
+			queue = queue[1:]
+			stack = append(stack, v)
+			for _, w := range neighbours[v] {
+				if dist[w] < 0 {
+					dist[w] = dist[v] + 1
+					queue = append(queue, w)
+				}
+				if dist[w] == dist[v]+1 {
+					sigma[w] += sigma[v]
+					predecessors[w] = append(predecessors[w], v)
+				}
+			}
+		}
+		delta := make([]float64, n)
+		for i := len(stack) - 1; i >= 0; i-- {
+			w := stack[i]
+			for _, v := range predecessors[w] {
+				delta[v] += sigma[v] / sigma[w] * (1 + delta[w])
+			}
+			if w != s {
+				centrality[w] += delta[w]
+			}
+		}
+	}
+	if n > 2 {
+		scale := 1 / float64((n-1)*(n-2))
+		for i := range centrality {
+			centrality[i] *= scale
+		}
+	}
+	return centrality
+}
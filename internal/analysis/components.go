@@ -0,0 +1,105 @@
+package analysis
+
+// weakComponents ignores edge directions
+func (g *digraph) weakComponents() []int {
+	parent := make([]int, len(g.nodes))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range g.nodes {
+		for j := range g.out[i] {
+			a, b := find(i), find(j)
+			// the smallest index represents the component
+			if a < b {
+				parent[b] = a
+			} else if b < a {
+				parent[a] = b
+			}
+		}
+	}
+	roots := make([]int, len(g.nodes))
+	for i := range roots {
+		roots[i] = find(i)
+	}
+	return denseIds(roots)
+}
+
+// strongComponents groups addresses reaching each other (Tarjan)
+func (g *digraph) strongComponents() []int {
+	n := len(g.nodes)
+	index := make([]int, n)
+	low := make([]int, n)
+	onStack := make([]bool, n)
+	component := make([]int, n)
+	for i := range index {
+		index[i] = -1
+	}
+	stack := []int{}
+	counter := 0
+
+	var connect func(v int)
+	connect = func(v int) {
+		index[v] = counter
+		low[v] = counter
+		counter++
+		stack = append(stack, v)
+		onStack[v] = true
+		for _, w := range sortedKeys(g.out[v]) {
+			if index[w] < 0 {
+				connect(w)
+				low[v] = min(low[v], low[w])
+			} else if onStack[w] {
+				low[v] = min(low[v], index[w])
+			}
+		}
+		if low[v] != index[v] {
+			return
+		}
+		// v roots a component, label it with its smallest member
+		members := []int{}
+		for {
+			w := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[w] = false
+			members = append(members, w)
+			if w == v {
+				break
+			}
+		}
+		smallest := members[0]
+		for _, w := range members {
+			smallest = min(smallest, w)
+		}
+		for _, w := range members {
+			component[w] = smallest
+		}
+	}
+	for v := 0; v < n; v++ {
+		if index[v] < 0 {
+			connect(v)
+		}
+	}
+	return denseIds(component)
+}
+
+// denseIds renumbers labels from 0 in the order of their first node
+func denseIds(labels []int) []int {
+	ids := make(map[int]int)
+	result := make([]int, len(labels))
+	for i, label := range labels {
+		id, exists := ids[label]
+		if !exists {
+			id = len(ids)
+			ids[label] = id
+		}
+		result[i] = id
+	}
+	return result
+}
@@ -0,0 +1,279 @@
+package analysis
+
+import (
+	"chain-traverser/internal/storage/redis"
+	"chain-traverser/internal/traverser"
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	DEFAULT_CYCLE_LENGTH = 4
+	MAX_CYCLE_LENGTH     = 8
+	DEFAULT_CYCLE_LIMIT  = 100
+	// the search of one graph stops after this many steps
+	CYCLE_STEP_BUDGET = 1_000_000
+	// reason a cycle search stopped early
+	TRUNCATED_CYCLE_BUDGET = "cycle_budget"
+)
+
+// Cycle is a route of txs leaving an address and coming back to it,
+// every tx happening after the previous one
+type Cycle struct {
+	// addresses in hop order, starting with the one funds return to
+	Route []string
+	// earliest txs completing the route
+	Txs          []traverser.Tx
+	TotalUsdFlow decimal.Decimal
+	// USD of the tx closing the cycle
+	ReturnedUsd decimal.Decimal
+	FirstBlock  int
+	LastBlock   int
+	// round trips over the route that don't overlap in time
+	Repeats int
+}
+
+type CycleOptions struct {
+	// only cycles through this address when set
+	Through   string
+	MaxLength int
+	Limit     int
+	// search steps, CYCLE_STEP_BUDGET if not set
+	stepBudget int
+}
+
+func (opts *CycleOptions) applyDefaults() {
+	if opts.MaxLength == 0 {
+		opts.MaxLength = DEFAULT_CYCLE_LENGTH
+	}
+	if opts.Limit == 0 {
+		opts.Limit = DEFAULT_CYCLE_LIMIT
+	}
+	if opts.stepBudget == 0 {
+		opts.stepBudget = CYCLE_STEP_BUDGET
+	}
+}
+
+func (opts CycleOptions) Validate() error {
+	if opts.MaxLength < 2 || opts.MaxLength > MAX_CYCLE_LENGTH {
+		return fmt.Errorf("%w: cycle length must be within 2..%d", traverser.ErrInvalidQuery, MAX_CYCLE_LENGTH)
+	}
+	if opts.Limit < 0 {
+		return fmt.Errorf("%w: negative cycle limit", traverser.ErrInvalidQuery)
+	}
+	return nil
+}
+
+// before orders txs in time
+func before(a, b traverser.Tx) bool {
+	if a.Block != b.Block {
+		return a.Block < b.Block
+	}
+	return a.Index < b.Index
+}
+
+// timeline holds the txs between every pair of addresses in time order
+type timeline map[string]map[string][]traverser.Tx
+
+func newTimeline(graph *traverser.Graph) timeline {
+	t := make(timeline)
+	for _, tx := range *graph.Txs {
+		if tx.From == tx.To {
+			continue
+		}
+		if t[tx.From] == nil {
+			t[tx.From] = make(map[string][]traverser.Tx)
+		}
+		t[tx.From][tx.To] = append(t[tx.From][tx.To], tx)
+	}
+	for _, targets := range t {
+		for _, txs := range targets {
+			sort.Slice(txs, func(i, j int) bool { return before(txs[i], txs[j]) })
+		}
+	}
+	return t
+}
+
+// next returns the earliest tx from -> to after the given one, or after nothing if nil.
+// A later tx could only constrain the rest of a route more.
+func (t timeline) next(from, to string, after *traverser.Tx) (traverser.Tx, bool) {
+	txs := t[from][to]
+	i := 0
+	if after != nil {
+		i = sort.Search(len(txs), func(i int) bool { return before(*after, txs[i]) })
+	}
+	if i == len(txs) {
+		return traverser.Tx{}, false
+	}
+	return txs[i], true
+}
+
+// walk follows the route from the given tx on, taking the earliest tx of every hop
+func (t timeline) walk(route []string, after *traverser.Tx) ([]traverser.Tx, bool) {
+	txs := make([]traverser.Tx, 0, len(route))
+	for i := range route {
+		tx, ok := t.next(route[i], route[(i+1)%len(route)], after)
+		if !ok {
+			return nil, false
+		}
+		txs = append(txs, tx)
+		after = &tx
+	}
+	return txs, true
+}
+
+// rotationKey identifies a route whatever address it starts at
+func rotationKey(route []string) string {
+	smallest := 0
+	for i := range route {
+		if route[i] < route[smallest] {
+			smallest = i
+		}
+	}
+	return fmt.Sprint(append(append([]string{}, route[smallest:]...), route[:smallest]...))
+}
+
+// Cycles finds the time ordered cycles of the graph up to opts.MaxLength hops,
+// every route is reported once with its earliest occurrence, earliest first.
+// The reason the search stopped early is returned, empty if it didn't.
+func Cycles(graph *traverser.Graph, opts CycleOptions) ([]Cycle, string, error) {
+	opts.applyDefaults()
+	if err := opts.Validate(); err != nil {
+		return nil, "", err
+	}
+	t := newTimeline(graph)
+	starts := []string{opts.Through}
+	if opts.Through == "" {
+		starts = make([]string, 0, len(t))
+		for addr := range t {
+			starts = append(starts, addr)
+		}
+		sort.Strings(starts)
+	}
+
+	found := make(map[string]Cycle)
+	steps := 0
+	truncated := ""
+	var search func(route []string, onRoute map[string]bool, last *traverser.Tx)
+	search = func(route []string, onRoute map[string]bool, last *traverser.Tx) {
+		current := route[len(route)-1]
+		targets := make([]string, 0, len(t[current]))
+		for to := range t[current] {
+			targets = append(targets, to)
+		}
+		sort.Strings(targets)
+		for _, to := range targets {
+			if steps++; steps > opts.stepBudget {
+				truncated = TRUNCATED_CYCLE_BUDGET
+				return
+			}
+			tx, ok := t.next(current, to, last)
+			if !ok {
+				continue
+			}
+			if to == route[0] {
+				if len(route) < 2 {
+					continue
+				}
+				cycle := newCycle(t, route)
+				key := rotationKey(route)
+				if opts.Through != "" {
+					key = fmt.Sprint(route)
+				}
+				if existing, ok := found[key]; !ok || before(cycle.Txs[0], existing.Txs[0]) {
+					found[key] = cycle
+				}
+				continue
+			}
+			if onRoute[to] || len(route) == opts.MaxLength {
+				continue
+			}
+			onRoute[to] = true
+			search(append(route, to), onRoute, &tx)
+			delete(onRoute, to)
+			if truncated != "" {
+				return
+			}
+		}
+	}
+	for _, start := range starts {
+		search([]string{start}, map[string]bool{start: true}, nil)
+		if truncated != "" {
+			break
+		}
+	}
+
+	cycles := make([]Cycle, 0, len(found))
+	for _, cycle := range found {
+		cycles = append(cycles, cycle)
+	}
+	sort.Slice(cycles, func(i, j int) bool {
+		a, b := cycles[i].Txs[0], cycles[j].Txs[0]
+		if before(a, b) || before(b, a) {
+			return before(a, b)
+		}
+		return fmt.Sprint(cycles[i].Route) < fmt.Sprint(cycles[j].Route)
+	})
+	if opts.Limit > 0 && len(cycles) > opts.Limit {
+		cycles = cycles[:opts.Limit]
+	}
+	return cycles, truncated, nil
+}
+
+// newCycle describes the earliest occurrence of a route known to complete
+func newCycle(t timeline, route []string) Cycle {
+	route = append([]string{}, route...)
+	cycle := Cycle{Route: route}
+	var after *traverser.Tx
+	for {
+		txs, ok := t.walk(route, after)
+		if !ok {
+			break
+		}
+		if cycle.Repeats == 0 {
+			cycle.Txs = txs
+		}
+		cycle.Repeats++
+		after = &txs[len(txs)-1]
+	}
+	for _, tx := range cycle.Txs {
+		cycle.TotalUsdFlow = cycle.TotalUsdFlow.Add(tx.TotalUsdFlow)
+	}
+	cycle.ReturnedUsd = cycle.Txs[len(cycle.Txs)-1].TotalUsdFlow
+	cycle.FirstBlock = cycle.Txs[0].Block
+	cycle.LastBlock = cycle.Txs[len(cycle.Txs)-1].Block
+	return cycle
+}
+
+// RoundTrips collects the outputs of the root up to opts.MaxLength hops and finds
+// the cycles bringing funds back to it
+func RoundTrips(ctx context.Context, query traverser.Query, opts CycleOptions, redis *redis.RedisClient) ([]Cycle, *traverser.Graph, error) {
+	opts.applyDefaults()
+	if err := opts.Validate(); err != nil {
+		return nil, nil, err
+	}
+	if len(query.Roots) != 1 {
+		return nil, nil, fmt.Errorf("%w: round trips need exactly one root", traverser.ErrInvalidQuery)
+	}
+	opts.Through = query.Roots[0]
+	// the last address of a cycle is MaxLength - 1 hops away and must be expanded
+	query.Flow = traverser.FLOW_OUTPUT
+	query.Depth = opts.MaxLength
+	graph, err := traverser.CollectBFS(ctx, query, redis)
+	if err != nil {
+		return nil, nil, err
+	}
+	cycles, truncated, err := Cycles(graph, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if graph.Truncated == "" {
+		graph.Truncated = truncated
+	}
+	log.Info().Msgf("found %d round trips of %s over %d txs", len(cycles), opts.Through, len(*graph.Txs))
+	return cycles, graph, nil
+}
@@ -0,0 +1,126 @@
+package analysis
+
+import (
+	"chain-traverser/internal/traverser"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+// hop is a tx of 1 USD, its hash is "from>to@block"
+type hop struct {
+	from  string
+	to    string
+	block int
+}
+
+func timedGraph(hops []hop) *traverser.Graph {
+	txs := make(map[string]traverser.Tx)
+	for _, h := range hops {
+		hash := h.from + ">" + h.to + "@" + strconv.Itoa(h.block)
+		txs[hash] = traverser.Tx{TxHash: hash, From: h.from, To: h.to, Block: h.block, TotalUsdFlow: decimal.NewFromInt(1)}
+	}
+	return &traverser.Graph{Addrs: &map[string]traverser.Addr{}, Txs: &txs}
+}
+
+// describeCycles returns every cycle as its route, first block and repeats
+func describeCycles(cycles []Cycle) []string {
+	result := []string{}
+	for _, cycle := range cycles {
+		result = append(result, fmt.Sprintf("%s @%d x%d", strings.Join(cycle.Route, ">"), cycle.FirstBlock, cycle.Repeats))
+	}
+	return result
+}
+
+func TestCycles(t *testing.T) {
+	// a and b send back and forth twice
+	pingPong := []hop{{"a", "b", 1}, {"b", "a", 2}, {"a", "b", 3}, {"b", "a", 4}}
+	tests := []struct {
+		name          string
+		hops          []hop
+		opts          CycleOptions
+		wantCycles    []string
+		wantTruncated string
+	}{
+		{
+			name:       "hops in time order",
+			hops:       []hop{{"a", "b", 1}, {"b", "c", 2}, {"c", "a", 3}},
+			wantCycles: []string{"a>b>c @1 x1"},
+		},
+		{
+			name:       "funds can't leave before they arrive",
+			hops:       []hop{{"a", "b", 3}, {"b", "c", 2}, {"c", "a", 1}},
+			wantCycles: []string{},
+		},
+		{
+			name:       "a route is reported once whatever address it starts at",
+			hops:       pingPong,
+			wantCycles: []string{"a>b @1 x2"},
+		},
+		{
+			name:       "through an address",
+			hops:       pingPong,
+			opts:       CycleOptions{Through: "b"},
+			wantCycles: []string{"b>a @2 x1"},
+		},
+		{
+			name:       "max length",
+			hops:       []hop{{"a", "b", 1}, {"b", "c", 2}, {"c", "a", 3}, {"c", "b", 4}},
+			opts:       CycleOptions{MaxLength: 2},
+			wantCycles: []string{"b>c @2 x1"},
+		},
+		{
+			name:       "earliest first and limit",
+			hops:       []hop{{"c", "d", 1}, {"d", "c", 2}, {"a", "b", 3}, {"b", "a", 4}},
+			opts:       CycleOptions{Limit: 1},
+			wantCycles: []string{"c>d @1 x1"},
+		},
+		{
+			name:          "step budget",
+			hops:          []hop{{"a", "b", 1}, {"b", "c", 2}, {"c", "a", 3}},
+			opts:          CycleOptions{stepBudget: 2},
+			wantCycles:    []string{},
+			wantTruncated: TRUNCATED_CYCLE_BUDGET,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cycles, truncated, err := Cycles(timedGraph(tt.hops), tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := describeCycles(cycles); !slices.Equal(got, tt.wantCycles) {
+				t.Errorf("cycles %v, want %v", got, tt.wantCycles)
+			}
+			if truncated != tt.wantTruncated {
+				t.Errorf("truncated %q, want %q", truncated, tt.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestCyclesSameBlock(t *testing.T) {
+	// txs of one block are ordered by their index
+	graph := timedGraph(nil)
+	(*graph.Txs)["a>b"] = traverser.Tx{TxHash: "a>b", From: "a", To: "b", Block: 1, Index: 0}
+	(*graph.Txs)["b>a"] = traverser.Tx{TxHash: "b>a", From: "b", To: "a", Block: 1, Index: 1}
+	cycles, _, err := Cycles(graph, CycleOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := describeCycles(cycles); !slices.Equal(got, []string{"a>b @1 x1"}) {
+		t.Errorf("cycles %v, want [a>b @1 x1]", got)
+	}
+}
+
+func TestCyclesInvalidLength(t *testing.T) {
+	for _, length := range []int{1, MAX_CYCLE_LENGTH + 1} {
+		if _, _, err := Cycles(timedGraph(nil), CycleOptions{MaxLength: length}); err == nil {
+			t.Errorf("length %d accepted", length)
+		}
+	}
+}
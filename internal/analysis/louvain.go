@@ -0,0 +1,165 @@
+package analysis
+
+// most aggregation levels of the Louvain method, it usually settles after a handful
+const LOUVAIN_MAX_LEVELS = 20
+
+// undirected weighted graph the Louvain method works on
+type ugraph struct {
+	adj    []map[int]float64 // self loops hold the inner weight of aggregated nodes
+	degree []float64
+	total  float64 // twice the sum of edge weights
+}
+
+func (g *digraph) undirected() *ugraph {
+	u := &ugraph{adj: make([]map[int]float64, len(g.nodes)), degree: make([]float64, len(g.nodes))}
+	for i := range g.nodes {
+		u.adj[i] = make(map[int]float64)
+	}
+	for i := range g.nodes {
+		for j, w := range g.out[i] {
+			u.adj[i][j] += w
+			u.adj[j][i] += w
+		}
+	}
+	u.computeDegrees()
+	return u
+}
+
+func (u *ugraph) computeDegrees() {
+	u.total = 0
+	for i, neighbours := range u.adj {
+		u.degree[i] = 0
+		for j, w := range neighbours {
+			u.degree[i] += w
+			if j == i {
+				// a self loop counts twice in the degree
+				u.degree[i] += w
+			}
+		}
+		u.total += u.degree[i]
+	}
+}
+
+// moveNodes moves nodes to the neighbour community with the best modularity gain
+// until no move improves it, returns the community of every node and whether any moved
+func (u *ugraph) moveNodes() ([]int, bool) {
+	n := len(u.adj)
+	community := make([]int, n)
+	communityDegree := make([]float64, n)
+	for i := range community {
+		community[i] = i
+		communityDegree[i] = u.degree[i]
+	}
+	moved := false
+	for improved := true; improved; {
+		improved = false
+		for i := 0; i < n; i++ {
+			current := community[i]
+			// weights from i to every neighbour community
+			links := make(map[int]float64)
+			for _, j := range sortedKeys(u.adj[i]) {
+				if j != i {
+					links[community[j]] += u.adj[i][j]
+				}
+			}
+			communityDegree[current] -= u.degree[i]
+			best := current
+			bestGain := links[current] - communityDegree[current]*u.degree[i]/u.total
+			for _, c := range sortedKeys(links) {
+				gain := links[c] - communityDegree[c]*u.degree[i]/u.total
+				if gain > bestGain+1e-12 {
+					best, bestGain = c, gain
+				}
+			}
+			communityDegree[best] += u.degree[i]
+			if best != current {
+				community[i] = best
+				improved = true
+				moved = true
+			}
+		}
+	}
+	return denseIds(community), moved
+}
+
+// aggregate merges every community into one node
+func (u *ugraph) aggregate(community []int) *ugraph {
+	size := 0
+	for _, c := range community {
+		size = max(size, c+1)
+	}
+	next := &ugraph{adj: make([]map[int]float64, size), degree: make([]float64, size)}
+	for i := range next.adj {
+		next.adj[i] = make(map[int]float64)
+	}
+	for i, neighbours := range u.adj {
+		for j, w := range neighbours {
+			// every undirected edge is seen from both ends, loops once
+			if i == j {
+				next.adj[community[i]][community[i]] += w
+			} else if i < j {
+				a, b := community[i], community[j]
+				if a == b {
+					next.adj[a][a] += w
+				} else {
+					next.adj[a][b] += w
+					next.adj[b][a] += w
+				}
+			}
+		}
+	}
+	next.computeDegrees()
+	return next
+}
+
+// modularity of a partition of the graph
+func (u *ugraph) modularity(community []int) float64 {
+	if u.total == 0 {
+		return 0
+	}
+	inner := make(map[int]float64)
+	degree := make(map[int]float64)
+	for i, neighbours := range u.adj {
+		degree[community[i]] += u.degree[i]
+		for j, w := range neighbours {
+			if community[i] == community[j] {
+				if i == j {
+					inner[community[i]] += 2 * w
+				} else {
+					inner[community[i]] += w
+				}
+			}
+		}
+	}
+	q := 0.0
+	for c, d := range degree {
+		q += inner[c]/u.total - (d/u.total)*(d/u.total)
+	}
+	return q
+}
+
+// louvain detects communities on the undirected weighted graph,
+// returns the community of every node and the modularity of the partition
+func (g *digraph) louvain() ([]int, float64) {
+	u := g.undirected()
+	membership := make([]int, len(g.nodes))
+	for i := range membership {
+		membership[i] = i
+	}
+	if u.total == 0 {
+		return denseIds(membership), 0
+	}
+	level := u
+	for i := 0; i < LOUVAIN_MAX_LEVELS; i++ {
+		community, moved := level.moveNodes()
+		if !moved {
+			break
+		}
+		for node := range membership {
+			membership[node] = community[membership[node]]
+		}
+		level = level.aggregate(community)
+	}
+	membership = denseIds(membership)
+	return membership, u.modularity(membership)
+}
@@ -0,0 +1,231 @@
+package analysis
+
+import (
+	"chain-traverser/internal/storage/redis"
+	"chain-traverser/internal/traverser"
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	DEFAULT_PEEL_MAX_HOPS = 20
+	MAX_PEEL_MAX_HOPS     = 200
+	// share of the USD an address sends that must go to the next hop
+	DEFAULT_PEEL_MIN_SHARE = 0.7
+	// outputs of a hop beyond which it is a split, not a peel
+	DEFAULT_PEEL_MAX_OUTPUTS = 4
+	// blocks after the first output of a hop its other outputs may follow, about a day
+	DEFAULT_PEEL_MAX_GAP = 7200
+	// hops with a side transfer a chain needs to be reported as a peel chain
+	MIN_PEEL_HOPS = 3
+)
+
+// reasons a peel chain stopped
+const (
+	PEEL_STOP_NO_OUTPUT  = "no_output"
+	PEEL_STOP_SPLIT      = "split"
+	PEEL_STOP_FAN_OUT    = "fan_out"
+	PEEL_STOP_LOOP       = "loop"
+	PEEL_STOP_UNEXPANDED = "unexpanded" // the next address is a hub or a labelled service
+	PEEL_STOP_MAX_HOPS   = "max_hops"
+	PEEL_STOP_NO_VALUE   = "no_value"
+)
+
+// PeelHop is what an address of the chain did with the funds it received
+type PeelHop struct {
+	Address string
+	// the dominant output, received by the next address of the chain
+	Forward traverser.Tx
+	// the other outputs of the hop
+	Peels      []traverser.Tx
+	ForwardUsd decimal.Decimal
+	PeeledUsd  decimal.Decimal
+	// share of the USD sent by the hop that was forwarded
+	Share float64
+}
+
+type PeelChain struct {
+	Start string
+	Hops  []PeelHop
+	// PEEL_STOP_* reason the chain ended, or the timeout or cancelled truncation reason
+	Stop string
+	// last address reached, funds forwarded to it were not followed further
+	End string
+	// hops with at least one side transfer
+	PeelHops  int
+	PeeledUsd decimal.Decimal
+	// true when the chain has at least MIN_PEEL_HOPS peeling hops
+	IsPeelChain bool
+}
+
+type PeelOptions struct {
+	MaxHops    int
+	MinShare   float64
+	MaxOutputs int
+	MaxGap     int
+}
+
+func (opts *PeelOptions) applyDefaults() {
+	if opts.MaxHops == 0 {
+		opts.MaxHops = DEFAULT_PEEL_MAX_HOPS
+	}
+	if opts.MinShare == 0 {
+		opts.MinShare = DEFAULT_PEEL_MIN_SHARE
+	}
+	if opts.MaxOutputs == 0 {
+		opts.MaxOutputs = DEFAULT_PEEL_MAX_OUTPUTS
+	}
+	if opts.MaxGap == 0 {
+		opts.MaxGap = DEFAULT_PEEL_MAX_GAP
+	}
+}
+
+func (opts PeelOptions) Validate() error {
+	if opts.MaxHops < 1 || opts.MaxHops > MAX_PEEL_MAX_HOPS {
+		return fmt.Errorf("%w: peel hops must be within 1..%d", traverser.ErrInvalidQuery, MAX_PEEL_MAX_HOPS)
+	}
+	if opts.MinShare <= 0.5 || opts.MinShare > 1 {
+		return fmt.Errorf("%w: peel share %f out of 0.5..1", traverser.ErrInvalidQuery, opts.MinShare)
+	}
+	if opts.MaxOutputs < 1 || opts.MaxGap < 0 {
+		return fmt.Errorf("%w: negative peel limit", traverser.ErrInvalidQuery)
+	}
+	return nil
+}
+
+// explorer reads addresses and their transactions, a *traverser.Explorer outside of tests
+type explorer interface {
+	Address(ctx context.Context, hash string, depth int) (*traverser.Addr, error)
+	Transactions(ctx context.Context, hash string, flow string) ([]traverser.Tx, error)
+	Stats() traverser.FetchStats
+}
+
+// hopOutputs returns the outputs of an address spending what it received with
+// the given tx: outputs after it, up to MaxGap blocks after the first one
+func hopOutputs(txs []traverser.Tx, received *traverser.Tx, maxGap int) []traverser.Tx {
+	sort.Slice(txs, func(i, j int) bool { return before(txs[i], txs[j]) })
+	outputs := []traverser.Tx{}
+	for _, tx := range txs {
+		if tx.From == tx.To || (received != nil && !before(*received, tx)) {
+			continue
+		}
+		if len(outputs) > 0 && tx.Block > outputs[0].Block+maxGap {
+			break
+		}
+		outputs = append(outputs, tx)
+	}
+	return outputs
+}
+
+// FollowPeelChain follows from the query root the dominant output of every address,
+// recording the smaller outputs peeled off on the way, until the pattern breaks
+func FollowPeelChain(ctx context.Context, query traverser.Query, opts PeelOptions, redis *redis.RedisClient) (*PeelChain, error) {
+	query.Flow = traverser.FLOW_OUTPUT
+	explorer, err := traverser.NewExplorer(query, redis)
+	if err != nil {
+		return nil, err
+	}
+	return followPeelChain(ctx, query, opts, explorer)
+}
+
+func followPeelChain(ctx context.Context, query traverser.Query, opts PeelOptions, explorer explorer) (*PeelChain, error) {
+	opts.applyDefaults()
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if len(query.Roots) != 1 {
+		return nil, fmt.Errorf("%w: a peel chain needs exactly one start", traverser.ErrInvalidQuery)
+	}
+
+	chain := &PeelChain{Start: query.Roots[0], End: query.Roots[0]}
+	onChain := map[string]bool{chain.Start: true}
+	var received *traverser.Tx
+	for chain.Stop == "" {
+		if len(chain.Hops) == opts.MaxHops {
+			chain.Stop = PEEL_STOP_MAX_HOPS
+			break
+		}
+		if ctx.Err() != nil {
+			chain.Stop = traverser.TruncatedBy(ctx)
+			break
+		}
+		current := chain.End
+		if current != chain.Start {
+			addr, err := explorer.Address(ctx, current, len(chain.Hops))
+			if traverser.IsCancelled(err) {
+				// the hops followed so far are kept
+				chain.Stop = traverser.TruncatedBy(ctx)
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if !addr.NeedTraverse {
+				chain.Stop = PEEL_STOP_UNEXPANDED
+				break
+			}
+		}
+		txs, err := explorer.Transactions(ctx, current, traverser.FLOW_OUTPUT)
+		if traverser.IsCancelled(err) {
+			chain.Stop = traverser.TruncatedBy(ctx)
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		outputs := hopOutputs(txs, received, opts.MaxGap)
+		if len(outputs) == 0 {
+			chain.Stop = PEEL_STOP_NO_OUTPUT
+			break
+		}
+		if len(outputs) > opts.MaxOutputs {
+			chain.Stop = PEEL_STOP_FAN_OUT
+			break
+		}
+
+		total := decimal.Zero
+		dominant := 0
+		for i, tx := range outputs {
+			total = total.Add(tx.TotalUsdFlow)
+			if tx.TotalUsdFlow.GreaterThan(outputs[dominant].TotalUsdFlow) {
+				dominant = i
+			}
+		}
+		if !total.IsPositive() {
+			chain.Stop = PEEL_STOP_NO_VALUE
+			break
+		}
+		forward := outputs[dominant]
+		share := forward.TotalUsdFlow.Div(total).InexactFloat64()
+		if share < opts.MinShare {
+			chain.Stop = PEEL_STOP_SPLIT
+			break
+		}
+
+		hop := PeelHop{Address: current, Forward: forward, ForwardUsd: forward.TotalUsdFlow, PeeledUsd: total.Sub(forward.TotalUsdFlow), Share: share}
+		for i, tx := range outputs {
+			if i != dominant {
+				hop.Peels = append(hop.Peels, tx)
+			}
+		}
+		chain.Hops = append(chain.Hops, hop)
+		if len(hop.Peels) > 0 {
+			chain.PeelHops++
+			chain.PeeledUsd = chain.PeeledUsd.Add(hop.PeeledUsd)
+		}
+		chain.End = forward.To
+		received = &forward
+		if onChain[forward.To] {
+			chain.Stop = PEEL_STOP_LOOP
+			break
+		}
+		onChain[forward.To] = true
+	}
+	chain.IsPeelChain = chain.PeelHops >= MIN_PEEL_HOPS
+	log.Info().Msgf("peel chain from %s: %d hops, %d peeling, stopped on %s, %s", chain.Start, len(chain.Hops), chain.PeelHops, chain.Stop, explorer.Stats())
+	return chain, nil
+}
@@ -0,0 +1,185 @@
+package analysis
+
+import (
+	"chain-traverser/internal/traverser"
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+// payment is a tx of usd dollars, its hash is "from>to@block"
+func payment(from string, to string, block int, usd int64) traverser.Tx {
+	return traverser.Tx{
+		TxHash:       from + ">" + to + "@" + strconv.Itoa(block),
+		From:         from,
+		To:           to,
+		Block:        block,
+		TotalUsdFlow: decimal.NewFromInt(usd),
+	}
+}
+
+// memExplorer serves a fixed set of txs, hubs are not expanded
+type memExplorer struct {
+	txs  []traverser.Tx
+	hubs map[string]bool
+}
+
+func (e *memExplorer) Address(ctx context.Context, hash string, depth int) (*traverser.Addr, error) {
+	return &traverser.Addr{Hash: hash, Depth: depth, NeedTraverse: !e.hubs[hash]}, nil
+}
+
+func (e *memExplorer) Transactions(ctx context.Context, hash string, flow string) ([]traverser.Tx, error) {
+	txs := []traverser.Tx{}
+	for _, tx := range e.txs {
+		if flow != traverser.FLOW_INPUT && tx.From == hash || flow != traverser.FLOW_OUTPUT && tx.To == hash {
+			txs = append(txs, tx)
+		}
+	}
+	return txs, nil
+}
+
+func (e *memExplorer) Stats() traverser.FetchStats { return traverser.FetchStats{} }
+
+func TestFollowPeelChain(t *testing.T) {
+	// every hop forwards most of what it received and peels a little off
+	peels := []traverser.Tx{
+		payment("s", "a", 1, 100), payment("s", "x1", 1, 10),
+		payment("a", "b", 2, 90), payment("a", "x2", 2, 5),
+		payment("b", "c", 3, 80), payment("b", "x3", 3, 5),
+	}
+	tests := []struct {
+		name     string
+		txs      []traverser.Tx
+		hubs     map[string]bool
+		opts     PeelOptions
+		wantHops []string // address of every hop
+		wantStop string
+		wantEnd  string
+		wantPeel bool
+	}{
+		{
+			name:     "peel chain",
+			txs:      peels,
+			wantHops: []string{"s", "a", "b"},
+			wantStop: PEEL_STOP_NO_OUTPUT,
+			wantEnd:  "c",
+			wantPeel: true,
+		},
+		{
+			name:     "max hops",
+			txs:      peels,
+			opts:     PeelOptions{MaxHops: 2},
+			wantHops: []string{"s", "a"},
+			wantStop: PEEL_STOP_MAX_HOPS,
+			wantEnd:  "b",
+		},
+		{
+			name:     "unexpanded",
+			txs:      peels,
+			hubs:     map[string]bool{"b": true},
+			wantHops: []string{"s", "a"},
+			wantStop: PEEL_STOP_UNEXPANDED,
+			wantEnd:  "b",
+		},
+		{
+			name:     "split",
+			txs:      []traverser.Tx{payment("s", "a", 1, 50), payment("s", "b", 1, 50)},
+			wantHops: []string{},
+			wantStop: PEEL_STOP_SPLIT,
+			wantEnd:  "s",
+		},
+		{
+			name: "fan out",
+			txs: []traverser.Tx{
+				payment("s", "a", 1, 100), payment("s", "b", 1, 1), payment("s", "c", 1, 1),
+				payment("s", "d", 1, 1), payment("s", "e", 1, 1),
+			},
+			wantHops: []string{},
+			wantStop: PEEL_STOP_FAN_OUT,
+			wantEnd:  "s",
+		},
+		{
+			name:     "loop",
+			txs:      []traverser.Tx{payment("s", "a", 1, 100), payment("a", "s", 2, 90)},
+			wantHops: []string{"s", "a"},
+			wantStop: PEEL_STOP_LOOP,
+			wantEnd:  "s",
+		},
+		{
+			name:     "outputs before the funds arrived are left out",
+			txs:      []traverser.Tx{payment("a", "x", 1, 500), payment("s", "a", 2, 100), payment("a", "b", 3, 90)},
+			wantHops: []string{"s", "a"},
+			wantStop: PEEL_STOP_NO_OUTPUT,
+			wantEnd:  "b",
+		},
+		{
+			name:     "outputs within the gap make a split",
+			txs:      []traverser.Tx{payment("s", "a", 1, 100), payment("a", "b", 2, 90), payment("a", "x", 20, 50)},
+			wantHops: []string{"s"},
+			wantStop: PEEL_STOP_SPLIT,
+			wantEnd:  "a",
+		},
+		{
+			name:     "outputs beyond the gap are left out",
+			txs:      []traverser.Tx{payment("s", "a", 1, 100), payment("a", "b", 2, 90), payment("a", "x", 20, 50)},
+			opts:     PeelOptions{MaxGap: 10},
+			wantHops: []string{"s", "a"},
+			wantStop: PEEL_STOP_NO_OUTPUT,
+			wantEnd:  "b",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := traverser.Query{Roots: []string{"s"}}
+			chain, err := followPeelChain(context.Background(), query, tt.opts, &memExplorer{txs: tt.txs, hubs: tt.hubs})
+			if err != nil {
+				t.Fatal(err)
+			}
+			hops := []string{}
+			for _, hop := range chain.Hops {
+				hops = append(hops, hop.Address)
+			}
+			if strings.Join(hops, ">") != strings.Join(tt.wantHops, ">") {
+				t.Errorf("hops %v, want %v", hops, tt.wantHops)
+			}
+			if chain.Stop != tt.wantStop || chain.End != tt.wantEnd {
+				t.Errorf("stopped on %q at %s, want %q at %s", chain.Stop, chain.End, tt.wantStop, tt.wantEnd)
+			}
+			if chain.IsPeelChain != tt.wantPeel {
+				t.Errorf("peel chain %v, want %v", chain.IsPeelChain, tt.wantPeel)
+			}
+		})
+	}
+}
+
+func TestFollowPeelChainPeeled(t *testing.T) {
+	txs := []traverser.Tx{
+		payment("s", "a", 1, 100), payment("s", "x1", 1, 10),
+		payment("a", "b", 2, 90), payment("a", "x2", 2, 5), payment("a", "x3", 2, 3),
+	}
+	chain, err := followPeelChain(context.Background(), traverser.Query{Roots: []string{"s"}}, PeelOptions{}, &memExplorer{txs: txs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chain.PeelHops != 2 || !chain.PeeledUsd.Equal(decimal.NewFromInt(18)) {
+		t.Errorf("%d peeling hops, %s USD peeled, want 2 and 18", chain.PeelHops, chain.PeeledUsd)
+	}
+	if peels := len(chain.Hops[1].Peels); peels != 2 {
+		t.Errorf("%d peels of the second hop, want 2", peels)
+	}
+}
+
+func TestFollowPeelChainCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	chain, err := followPeelChain(ctx, traverser.Query{Roots: []string{"s"}}, PeelOptions{}, &memExplorer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chain.Stop != traverser.TRUNCATED_CANCELLED {
+		t.Errorf("stopped on %q, want %q", chain.Stop, traverser.TRUNCATED_CANCELLED)
+	}
+}
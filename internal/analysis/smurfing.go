@@ -0,0 +1,342 @@
+package analysis
+
+import (
+	"chain-traverser/internal/storage/redis"
+	"chain-traverser/internal/traverser"
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// counterparties, or txs for structuring, a pattern needs to be scored
+	DEFAULT_SMURF_MIN_COUNTERPARTIES = 5
+	// a counterparty with at most this many txs overall is fresh
+	DEFAULT_FRESH_MAX_TXS = 3
+	// counters read per round trip when scanning
+	SCAN_COUNTER_BATCH = 10_000
+)
+
+type SmurfOptions struct {
+	MinCounterparties int
+	FreshMaxTxs       int64
+}
+
+func (opts *SmurfOptions) applyDefaults() {
+	if opts.MinCounterparties == 0 {
+		opts.MinCounterparties = DEFAULT_SMURF_MIN_COUNTERPARTIES
+	}
+	if opts.FreshMaxTxs == 0 {
+		opts.FreshMaxTxs = DEFAULT_FRESH_MAX_TXS
+	}
+}
+
+func (opts SmurfOptions) Validate() error {
+	if opts.MinCounterparties < 2 || opts.FreshMaxTxs < 1 {
+		return fmt.Errorf("%w: min counterparties must be at least 2 and fresh max txs at least 1", traverser.ErrInvalidQuery)
+	}
+	return nil
+}
+
+// FlowScores rates how much an address behaves like a smurfing step, scores are within 0..1
+type FlowScores struct {
+	Address string
+	// receives from many fresh addresses and forwards to one consolidation point
+	FanIn float64
+	// splits one deposit between many fresh addresses
+	FanOut float64
+	// sends or receives many transfers of about the same USD amount
+	Structuring float64
+
+	Senders         int
+	Recipients      int
+	FreshSenders    []string
+	FreshRecipients []string
+	// largest recipient and largest sender by USD
+	Consolidation string
+	Source        string
+	InUsd         decimal.Decimal
+	OutUsd        decimal.Decimal
+}
+
+func (s FlowScores) Max() float64 {
+	return max(s.FanIn, s.FanOut, s.Structuring)
+}
+
+// amountStats keeps what the spread of tx amounts needs
+type amountStats struct {
+	n     int
+	sum   float64
+	sumSq float64
+}
+
+func (s *amountStats) add(v float64) {
+	s.n++
+	s.sum += v
+	s.sumSq += v * v
+}
+
+// uniformity is 1 for equal amounts and falls to 0 as their coefficient of variation reaches 1
+func (s amountStats) uniformity() float64 {
+	if s.n == 0 || s.sum <= 0 {
+		return 0
+	}
+	mean := s.sum / float64(s.n)
+	variance := max(0, s.sumSq/float64(s.n)-mean*mean)
+	return max(0, 1-math.Sqrt(variance)/mean)
+}
+
+// FlowStats accumulates the transfers of an address within a window
+type FlowStats struct {
+	Address    string
+	senders    map[string]decimal.Decimal
+	recipients map[string]decimal.Decimal
+	inUsd      decimal.Decimal
+	outUsd     decimal.Decimal
+	inAmounts  amountStats
+	outAmounts amountStats
+}
+
+func NewFlowStats(address string) *FlowStats {
+	return &FlowStats{
+		Address:    address,
+		senders:    make(map[string]decimal.Decimal),
+		recipients: make(map[string]decimal.Decimal),
+	}
+}
+
+// Add counts a tx of the address, other txs and self transfers are ignored
+func (f *FlowStats) Add(tx traverser.Tx) {
+	if tx.From == tx.To {
+		return
+	}
+	usd := tx.TotalUsdFlow
+	switch f.Address {
+	case tx.To:
+		f.senders[tx.From] = f.senders[tx.From].Add(usd)
+		f.inUsd = f.inUsd.Add(usd)
+		if usd.IsPositive() {
+			f.inAmounts.add(usd.InexactFloat64())
+		}
+	case tx.From:
+		f.recipients[tx.To] = f.recipients[tx.To].Add(usd)
+		f.outUsd = f.outUsd.Add(usd)
+		if usd.IsPositive() {
+			f.outAmounts.add(usd.InexactFloat64())
+		}
+	}
+}
+
+// Candidate reports whether the address has enough transfers for any pattern
+func (f *FlowStats) Candidate(minCounterparties int) bool {
+	return len(f.senders) >= minCounterparties || len(f.recipients) >= minCounterparties ||
+		f.inAmounts.n >= minCounterparties || f.outAmounts.n >= minCounterparties
+}
+
+// Counterparties lists the senders and recipients of the address
+func (f *FlowStats) Counterparties() []string {
+	addrs := make([]string, 0, len(f.senders)+len(f.recipients))
+	for addr := range f.senders {
+		addrs = append(addrs, addr)
+	}
+	for addr := range f.recipients {
+		if _, ok := f.senders[addr]; !ok {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// share is part/total, 0 when nothing moved
+func share(part, total decimal.Decimal) float64 {
+	if !total.IsPositive() {
+		return 0
+	}
+	return part.Div(total).InexactFloat64()
+}
+
+// largest returns the counterparty that moved the most USD, the smallest address on ties
+func largest(counterparties map[string]decimal.Decimal) (string, decimal.Decimal) {
+	top, topUsd := "", decimal.Zero
+	for addr, usd := range counterparties {
+		if top == "" || usd.GreaterThan(topUsd) || (usd.Equal(topUsd) && addr < top) {
+			top, topUsd = addr, usd
+		}
+	}
+	return top, topUsd
+}
+
+// fresh returns the fresh counterparties and the share of the flow they moved,
+// by USD or by count when no USD is known. Addresses without a counter count as fresh.
+func fresh(counterparties map[string]decimal.Decimal, total decimal.Decimal, counters map[string]int64, opts SmurfOptions) ([]string, float64) {
+	addrs := []string{}
+	usd := decimal.Zero
+	for addr, moved := range counterparties {
+		if counters[addr] <= opts.FreshMaxTxs {
+			addrs = append(addrs, addr)
+			usd = usd.Add(moved)
+		}
+	}
+	sort.Strings(addrs)
+	if len(counterparties) == 0 {
+		return addrs, 0
+	}
+	if !total.IsPositive() {
+		return addrs, float64(len(addrs)) / float64(len(counterparties))
+	}
+	return addrs, share(usd, total)
+}
+
+// Score rates the patterns, counters holds the tx counters of the counterparties
+func (f *FlowStats) Score(counters map[string]int64, opts SmurfOptions) FlowScores {
+	opts.applyDefaults()
+	scores := FlowScores{
+		Address:    f.Address,
+		Senders:    len(f.senders),
+		Recipients: len(f.recipients),
+		InUsd:      f.inUsd,
+		OutUsd:     f.outUsd,
+	}
+	consolidation, consolidatedUsd := largest(f.recipients)
+	source, depositUsd := largest(f.senders)
+	scores.Consolidation, scores.Source = consolidation, source
+
+	var freshIn, freshOut float64
+	scores.FreshSenders, freshIn = fresh(f.senders, f.inUsd, counters, opts)
+	scores.FreshRecipients, freshOut = fresh(f.recipients, f.outUsd, counters, opts)
+	if len(f.senders) >= opts.MinCounterparties {
+		scores.FanIn = freshIn * share(consolidatedUsd, f.outUsd)
+	}
+	if len(f.recipients) >= opts.MinCounterparties {
+		scores.FanOut = freshOut * share(depositUsd, f.inUsd)
+	}
+	if f.outAmounts.n >= opts.MinCounterparties {
+		scores.Structuring = f.outAmounts.uniformity()
+	}
+	if f.inAmounts.n >= opts.MinCounterparties {
+		scores.Structuring = max(scores.Structuring, f.inAmounts.uniformity())
+	}
+	return scores
+}
+
+// ScoreAddress rates the transfers of the query root within the query block range
+func ScoreAddress(ctx context.Context, query traverser.Query, opts SmurfOptions, redis *redis.RedisClient) (*FlowScores, error) {
+	opts.applyDefaults()
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if len(query.Roots) != 1 {
+		return nil, fmt.Errorf("%w: patterns are scored for exactly one address", traverser.ErrInvalidQuery)
+	}
+	query.Flow = traverser.FLOW_ALL
+	explorer, err := traverser.NewExplorer(query, redis)
+	if err != nil {
+		return nil, err
+	}
+	txs, err := explorer.Transactions(ctx, query.Roots[0], traverser.FLOW_ALL)
+	if err != nil {
+		return nil, err
+	}
+	stats := NewFlowStats(query.Roots[0])
+	for _, tx := range txs {
+		stats.Add(tx)
+	}
+	counters, err := redis.GetAddressTxNumbers(ctx, stats.Counterparties())
+	if err != nil {
+		return nil, err
+	}
+	scores := stats.Score(counters, opts)
+	log.Info().Msgf("flow patterns of %s over %d txs: fan in %.2f, fan out %.2f, structuring %.2f", stats.Address, len(txs), scores.FanIn, scores.FanOut, scores.Structuring)
+	return &scores, nil
+}
+
+// ScanFlows reads every block of the range and rates every address active in it,
+// addresses scoring at least minScore on a pattern are returned, best first
+func ScanFlows(ctx context.Context, fromBlock, toBlock int64, batchSize int, opts SmurfOptions, minScore float64, redis *redis.RedisClient) ([]FlowScores, error) {
+	return scanFlows(ctx, fromBlock, toBlock, batchSize, opts, minScore, redis)
+}
+
+// blockStore reads blocks and tx counters, a *redis.RedisClient outside of tests
+type blockStore interface {
+	GetBlocks(ctx context.Context, blockNumbers []string) (map[string]string, error)
+	GetAddressTxNumbers(ctx context.Context, addrs []string) (map[string]int64, error)
+}
+
+func scanFlows(ctx context.Context, fromBlock, toBlock int64, batchSize int, opts SmurfOptions, minScore float64, store blockStore) ([]FlowScores, error) {
+	opts.applyDefaults()
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	stats := make(map[string]*FlowStats)
+	add := func(addr string, tx traverser.Tx) {
+		s, ok := stats[addr]
+		if !ok {
+			s = NewFlowStats(addr)
+			stats[addr] = s
+		}
+		s.Add(tx)
+	}
+	for from := fromBlock; from <= toBlock; from += int64(batchSize) {
+		numbers := []string{}
+		for block := from; block < from+int64(batchSize) && block <= toBlock; block++ {
+			numbers = append(numbers, strconv.FormatInt(block, 10))
+		}
+		blobs, err := store.GetBlocks(ctx, numbers)
+		if err != nil {
+			return nil, err
+		}
+		for _, number := range numbers {
+			for _, tx := range traverser.ParseBlock(number, blobs[number]) {
+				add(tx.From, tx)
+				add(tx.To, tx)
+			}
+		}
+		log.Info().Msgf("scanned blocks %s..%s, %d addresses", numbers[0], numbers[len(numbers)-1], len(stats))
+	}
+
+	candidates := []*FlowStats{}
+	counterparties := make(map[string]bool)
+	for _, s := range stats {
+		if !s.Candidate(opts.MinCounterparties) {
+			continue
+		}
+		candidates = append(candidates, s)
+		for _, addr := range s.Counterparties() {
+			counterparties[addr] = true
+		}
+	}
+	addrs := make([]string, 0, len(counterparties))
+	for addr := range counterparties {
+		addrs = append(addrs, addr)
+	}
+	counters := make(map[string]int64, len(addrs))
+	for i := 0; i < len(addrs); i += SCAN_COUNTER_BATCH {
+		batch, err := store.GetAddressTxNumbers(ctx, addrs[i:min(i+SCAN_COUNTER_BATCH, len(addrs))])
+		if err != nil {
+			return nil, err
+		}
+		for addr, cnt := range batch {
+			counters[addr] = cnt
+		}
+	}
+
+	flagged := []FlowScores{}
+	for _, s := range candidates {
+		if scores := s.Score(counters, opts); scores.Max() >= minScore {
+			flagged = append(flagged, scores)
+		}
+	}
+	sort.Slice(flagged, func(i, j int) bool {
+		if flagged[i].Max() != flagged[j].Max() {
+			return flagged[i].Max() > flagged[j].Max()
+		}
+		return flagged[i].Address < flagged[j].Address
+	})
+	log.Info().Msgf("scored %d of %d addresses active in blocks %d..%d, %d flagged", len(candidates), len(stats), fromBlock, toBlock, len(flagged))
+	return flagged, nil
+}
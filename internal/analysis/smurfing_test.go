@@ -0,0 +1,183 @@
+package analysis
+
+import (
+	"chain-traverser/internal/traverser"
+	"context"
+	"math"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// flowStats adds the txs of address
+func flowStats(address string, txs []traverser.Tx) *FlowStats {
+	stats := NewFlowStats(address)
+	for _, tx := range txs {
+		stats.Add(tx)
+	}
+	return stats
+}
+
+// fanIn is x receiving from five addresses and forwarding everything to c
+func fanIn(amounts ...int64) []traverser.Tx {
+	txs := []traverser.Tx{}
+	total := int64(0)
+	for i, usd := range amounts {
+		txs = append(txs, payment("f"+strconv.Itoa(i), "x", i+1, usd))
+		total += usd
+	}
+	return append(txs, payment("x", "c", len(amounts)+1, total))
+}
+
+func TestFlowStatsScore(t *testing.T) {
+	// five recipients of a single deposit
+	fanOut := []traverser.Tx{payment("s", "x", 1, 100)}
+	for i := 0; i < 5; i++ {
+		fanOut = append(fanOut, payment("x", "r"+strconv.Itoa(i), i+2, 20))
+	}
+	old := map[string]int64{"f0": 4, "f1": 4, "f2": 4, "f3": 4, "f4": 4}
+
+	tests := []struct {
+		name            string
+		txs             []traverser.Tx
+		counters        map[string]int64
+		wantFanIn       float64
+		wantFanOut      float64
+		wantStructuring float64
+	}{
+		{
+			name:            "fan in from fresh senders",
+			txs:             fanIn(10, 10, 10, 10, 10),
+			wantFanIn:       1,
+			wantStructuring: 1,
+		},
+		{
+			name:            "senders with more txs are not fresh",
+			txs:             fanIn(10, 10, 10, 10, 10),
+			counters:        old,
+			wantStructuring: 1,
+		},
+		{
+			name:            "senders at the fresh threshold",
+			txs:             fanIn(10, 10, 10, 10, 10),
+			counters:        map[string]int64{"f0": 3, "f1": 3, "f2": 3, "f3": 3, "f4": 3},
+			wantFanIn:       1,
+			wantStructuring: 1,
+		},
+		{
+			name:            "fan in of one old sender",
+			txs:             fanIn(10, 10, 10, 10, 60),
+			counters:        map[string]int64{"f4": 4},
+			wantFanIn:       0.4,
+			wantStructuring: 1 - math.Sqrt(400)/20,
+		},
+		{
+			name:            "too few senders",
+			txs:             fanIn(10, 10, 10, 10),
+			wantFanIn:       0,
+			wantStructuring: 0,
+		},
+		{
+			name:            "fan out to fresh recipients",
+			txs:             fanOut,
+			wantFanOut:      1,
+			wantStructuring: 1,
+		},
+		{
+			name:            "amounts of different size",
+			txs:             fanIn(10, 20, 30, 40, 50),
+			counters:        old,
+			wantStructuring: 1 - math.Sqrt(200)/30,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scores := flowStats("x", tt.txs).Score(tt.counters, SmurfOptions{})
+			if !near(scores.FanIn, tt.wantFanIn) || !near(scores.FanOut, tt.wantFanOut) || !near(scores.Structuring, tt.wantStructuring) {
+				t.Errorf("fan in %f, fan out %f, structuring %f, want %f, %f, %f",
+					scores.FanIn, scores.FanOut, scores.Structuring, tt.wantFanIn, tt.wantFanOut, tt.wantStructuring)
+			}
+		})
+	}
+}
+
+func TestFlowStatsCounterparties(t *testing.T) {
+	scores := flowStats("x", fanIn(10, 10, 10, 10, 10)).Score(nil, SmurfOptions{})
+	if scores.Senders != 5 || scores.Recipients != 1 || len(scores.FreshSenders) != 5 {
+		t.Errorf("%d senders, %d recipients, %d fresh senders, want 5, 1, 5", scores.Senders, scores.Recipients, len(scores.FreshSenders))
+	}
+	if scores.Consolidation != "c" || scores.Source != "f0" {
+		t.Errorf("consolidation %s, source %s, want c and f0", scores.Consolidation, scores.Source)
+	}
+}
+
+// memBlocks serves blocks and counters
+type memBlocks struct {
+	blobs    map[string]string
+	counters map[string]int64
+}
+
+func (m *memBlocks) GetBlocks(ctx context.Context, blockNumbers []string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, number := range blockNumbers {
+		if blob, ok := m.blobs[number]; ok {
+			result[number] = blob
+		}
+	}
+	return result, nil
+}
+
+func (m *memBlocks) GetAddressTxNumbers(ctx context.Context, addrs []string) (map[string]int64, error) {
+	result := map[string]int64{}
+	for _, addr := range addrs {
+		if cnt, ok := m.counters[addr]; ok {
+			result[addr] = cnt
+		}
+	}
+	return result, nil
+}
+
+// newMemBlocks stores every tx in the blob of its block the way the indexer does
+func newMemBlocks(txs []traverser.Tx, counters map[string]int64) *memBlocks {
+	m := &memBlocks{blobs: map[string]string{}, counters: counters}
+	for _, tx := range txs {
+		number := strconv.Itoa(tx.Block)
+		m.blobs[number] += tx.From + ";" + tx.TxHash + ";" + tx.To + ";0;" + tx.TotalUsdFlow.String() + ";nil;0;0\n"
+	}
+	return m
+}
+
+func TestScanFlows(t *testing.T) {
+	// amounts of different size keep structuring low, only the fan in can flag x
+	txs := append(fanIn(10, 20, 30, 40, 50), payment("p", "q", 3, 1000))
+	tests := []struct {
+		name     string
+		counters map[string]int64
+		want     []string
+	}{
+		{
+			name: "fresh senders",
+			want: []string{"x"},
+		},
+		{
+			name:     "old senders",
+			counters: map[string]int64{"f0": 10, "f1": 10, "f2": 10, "f3": 10, "f4": 10},
+			want:     []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flagged, err := scanFlows(context.Background(), 1, 6, 2, SmurfOptions{}, 0.9, newMemBlocks(txs, tt.counters))
+			if err != nil {
+				t.Fatal(err)
+			}
+			addrs := []string{}
+			for _, scores := range flagged {
+				addrs = append(addrs, scores.Address)
+			}
+			if !slices.Equal(addrs, tt.want) {
+				t.Errorf("flagged %v, want %v", addrs, tt.want)
+			}
+		})
+	}
+}
@@ -1,7 +1,10 @@
 package eth
 
 import (
+	"chain-traverser/internal/storage"
 	"chain-traverser/internal/storage/redis"
+	"context"
+	"errors"
 	"fmt"
 
 	"github.com/shopspring/decimal"
@@ -120,7 +123,7 @@ var CURRENCIES = []string{
 }
 
 // if db does not have price for the block, try to get it for the previous 10 days
-func GetTokenPrice(blockTime uint64, redis *redis.RedisClient, currency string) (*decimal.Decimal, error) {
+func GetTokenPrice(ctx context.Context, blockTime uint64, redis *redis.RedisClient, currency string) (*decimal.Decimal, error) {
 	c := remapCurrency(currency)
 	if c == USD {
 		return &DECIMAL_ONE, nil
@@ -130,10 +133,14 @@ func GetTokenPrice(blockTime uint64, redis *redis.RedisClient, currency string)
 
 	for i := 1; i < MAX_PRICE_DEPTH_DAYS; i++ {
 		blockTime := normalizedBlockTime(blockTime, i)
-		price, pErr := redis.GetPrice(blockTime, c)
+		price, pErr := redis.GetPrice(ctx, blockTime, c)
 		if price != nil {
 			return price, nil
 		}
+		if !errors.Is(pErr, storage.ErrNotFound) {
+			// no point looking further back
+			return nil, pErr
+		}
 		err = pErr
 	}
 	return nil, fmt.Errorf("price not found for %s: %w", c, err)
@@ -20,6 +20,11 @@ type RedisConfig struct {
 	Password string        `envconfig:"REDIS_PASSWORD" default:""`
 	PoolSize int           `envconfig:"REDIS_POOL_SIZE" default:"10"`
 	Timeout  time.Duration `envconfig:"REDIS_TIMEOUT" default:"5s"`
+	// standalone or sentinel
+	Mode string `envconfig:"REDIS_MODE" default:"standalone"`
+	// comma separated sentinel nodes, REDIS_ADDRESS is used if empty
+	Addresses  []string `envconfig:"REDIS_ADDRESSES"`
+	MasterName string   `envconfig:"REDIS_SENTINEL_MASTER"`
 
 	// app's variables:
 	// for storing blocks
@@ -27,8 +32,9 @@ type RedisConfig struct {
 	// for storing statistics
 	ANALYTICS_DB int `envconfig:"REDIS_ANALYTICS_DB" default:"1"`
 	// for interprocess communication
-	QUEUE_DB   int    `envconfig:"REDIS_QUEUE_DB" default:"2"`
-	DB_VERSION string `envconfig:"REDIS_DB_VERSION" default:"1"`
+	QUEUE_DB int `envconfig:"REDIS_QUEUE_DB" default:"2"`
+	// keyspace version, empty to follow the version switched by cmd/migrator
+	DB_VERSION string `envconfig:"REDIS_DB_VERSION" default:""`
 }
 
 type IndexerConfig struct {
@@ -38,6 +44,25 @@ type IndexerConfig struct {
 
 type ApiConfig struct {
 	GraphSizeLimit int `envconfig:"API_GRAPH_SIZE_OUTPUT_LIMIT" default:"5000"`
+	// traversal budget of a request, the timeout query param can only lower it
+	RequestTimeout time.Duration `envconfig:"API_REQUEST_TIMEOUT" default:"30s"`
+	// max number of seed addresses of a multi-source traversal
+	MaxSeeds int `envconfig:"API_MAX_SEEDS" default:"100"`
+	// addresses a shortest path search may expand, the search answers with what it found when spent
+	PathNodeBudget int `envconfig:"API_PATH_NODE_BUDGET" default:"2000"`
+	// how long members of cluster nodes can be requested after the graph
+	ClusterTTL time.Duration `envconfig:"API_CLUSTER_TTL" default:"1h"`
+}
+
+type TraverserConfig struct {
+	// concurrent Redis round trips of a traversal, keep it below REDIS_POOL_SIZE
+	FetchWorkers int `envconfig:"TRAVERSER_FETCH_WORKERS" default:"8"`
+	// keys read per round trip
+	FetchBatchSize int `envconfig:"TRAVERSER_FETCH_BATCH_SIZE" default:"100"`
+	// memory for parsed blocks of a single traversal
+	CacheMB int `envconfig:"TRAVERSER_CACHE_MB" default:"256"`
+	// process-wide LRU of parsed blocks shared by traversals, 0 disables it
+	SharedCacheMB int `envconfig:"TRAVERSER_SHARED_CACHE_MB" default:"0"`
 }
 
 type Config struct {
@@ -45,6 +70,7 @@ type Config struct {
 	Eth           EthConfig
 	Indexer       IndexerConfig
 	Api           ApiConfig
+	Traverser     TraverserConfig
 	CryptoCompare CryptoCompareConfig
 }
 
@@ -0,0 +1,276 @@
+package paths
+
+import (
+	"chain-traverser/internal/storage"
+	"chain-traverser/internal/storage/redis"
+	"chain-traverser/internal/traverser"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// shortest paths first
+	RANK_LENGTH = "length"
+	// paths carrying the largest USD amount first
+	RANK_VALUE = "value"
+)
+
+const (
+	DEFAULT_NODE_BUDGET = 2000
+	// the search spent its node budget before exploring every candidate
+	TRUNCATED_NODE_BUDGET = "node_budget"
+	// paths considered per wanted path when ranking by value
+	VALUE_CANDIDATES_PER_PATH = 20
+)
+
+type SearchOptions struct {
+	K          int    // paths wanted, 1 if not set
+	Rank       string // RANK_*, RANK_LENGTH if not set
+	MaxHops    int    // DEFAULT_MAX_HOPS if not set
+	NodeBudget int    // addresses expanded on both sides, DEFAULT_NODE_BUDGET if not set
+	Query      traverser.Query
+}
+
+type SearchResult struct {
+	Paths     []Path
+	Expanded  int    // addresses expanded
+	Truncated string // why the search stopped early, empty if it didn't
+}
+
+// explorer reads addresses and their transactions, a *traverser.Explorer outside of tests
+type explorer interface {
+	Address(ctx context.Context, hash string, depth int) (*traverser.Addr, error)
+	Transactions(ctx context.Context, hash string, flow string) ([]traverser.Tx, error)
+	Stats() traverser.FetchStats
+}
+
+// side is one half of a bidirectional search
+type side struct {
+	flow     string         // FLOW_OUTPUT from the source, FLOW_INPUT to the target
+	dist     map[string]int // hops from the endpoint
+	frontier []string
+	depth    int
+}
+
+// Search finds the shortest paths from source to target with a bidirectional BFS:
+// outputs are expanded from the source and inputs from the target, always on the
+// side with the smaller frontier, until the sides meet. The search never expands
+// more than opts.NodeBudget addresses, so it stays fast on dense graphs.
+//
+// With opts.K > 1 the search goes on until K meeting addresses are found, then the
+// K shortest or, with RANK_VALUE, the K highest value paths of the explored graph are returned.
+func Search(ctx context.Context, source string, target string, opts SearchOptions, redis *redis.RedisClient) (*SearchResult, error) {
+	query := opts.Query
+	query.Roots = []string{source, target}
+	explorer, err := traverser.NewExplorer(query, redis)
+	if err != nil {
+		return nil, err
+	}
+	return search(ctx, source, target, opts, explorer)
+}
+
+func search(ctx context.Context, source string, target string, opts SearchOptions, explorer explorer) (*SearchResult, error) {
+	if opts.K <= 0 {
+		opts.K = 1
+	}
+	if opts.Rank == "" {
+		opts.Rank = RANK_LENGTH
+	}
+	if opts.Rank != RANK_LENGTH && opts.Rank != RANK_VALUE {
+		return nil, fmt.Errorf("%w: unknown rank %q", traverser.ErrInvalidQuery, opts.Rank)
+	}
+	if opts.MaxHops <= 0 {
+		opts.MaxHops = DEFAULT_MAX_HOPS
+	}
+	if opts.NodeBudget <= 0 {
+		opts.NodeBudget = DEFAULT_NODE_BUDGET
+	}
+
+	result := &SearchResult{Paths: []Path{}}
+	if source == target {
+		return result, nil
+	}
+	forward := &side{flow: traverser.FLOW_OUTPUT, dist: map[string]int{source: 0}, frontier: []string{source}}
+	backward := &side{flow: traverser.FLOW_INPUT, dist: map[string]int{target: 0}, frontier: []string{target}}
+	// best hop between every pair of addresses seen on either side
+	edges := make(map[string]map[string]Hop)
+	meetings := 0
+
+	for len(forward.frontier) > 0 && len(backward.frontier) > 0 && forward.depth+backward.depth < opts.MaxHops {
+		// K meeting addresses give at least K paths, ranking by value explores as much as allowed
+		if opts.Rank == RANK_LENGTH && meetings >= opts.K {
+			break
+		}
+		current, other := forward, backward
+		if len(backward.frontier) < len(forward.frontier) {
+			current, other = backward, forward
+		}
+
+		next := []string{}
+		for _, hash := range current.frontier {
+			if ctx.Err() != nil {
+				result.Truncated = traverser.TruncatedBy(ctx)
+				break
+			}
+			if result.Expanded >= opts.NodeBudget {
+				result.Truncated = TRUNCATED_NODE_BUDGET
+				break
+			}
+			addr, err := explorer.Address(ctx, hash, current.depth)
+			if err == nil && !addr.NeedTraverse {
+				continue
+			}
+			var txs []traverser.Tx
+			if err == nil {
+				txs, err = explorer.Transactions(ctx, hash, current.flow)
+			}
+			if errors.Is(err, storage.ErrUnavailable) {
+				return nil, err
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					continue
+				}
+				log.Err(err).Msgf("Search cant expand %s", hash)
+				continue
+			}
+			result.Expanded++
+
+			for _, tx := range txs {
+				if tx.From == tx.To {
+					continue
+				}
+				addEdge(edges, tx)
+				neighbour := tx.To
+				if current.flow == traverser.FLOW_INPUT {
+					neighbour = tx.From
+				}
+				if _, seen := current.dist[neighbour]; seen {
+					continue
+				}
+				current.dist[neighbour] = current.depth + 1
+				next = append(next, neighbour)
+				if _, met := other.dist[neighbour]; met {
+					meetings++
+				}
+			}
+		}
+		sort.Strings(next)
+		current.frontier = next
+		current.depth++
+		if result.Truncated != "" {
+			break
+		}
+	}
+
+	candidates := opts.K
+	if opts.Rank == RANK_VALUE {
+		candidates = opts.K * VALUE_CANDIDATES_PER_PATH
+	}
+	found := shortestPaths(edges, source, target, opts.MaxHops, candidates)
+	if opts.Rank == RANK_VALUE {
+		sort.SliceStable(found, func(i, j int) bool {
+			return found[i].Value().GreaterThan(found[j].Value())
+		})
+	}
+	if len(found) > opts.K {
+		found = found[:opts.K]
+	}
+	result.Paths = found
+	log.Info().Msgf("Search %s -> %s expanded %d addresses, found %d paths, truncated: %q, fetch stats: %s",
+		source, target, result.Expanded, len(found), result.Truncated, explorer.Stats())
+	return result, nil
+}
+
+// addEdge keeps the largest tx between two addresses, it is the hop paths use
+func addEdge(edges map[string]map[string]Hop, tx traverser.Tx) {
+	if edges[tx.From] == nil {
+		edges[tx.From] = make(map[string]Hop)
+	}
+	current, exists := edges[tx.From][tx.To]
+	if !exists || tx.TotalUsdFlow.GreaterThan(current.TotalUsdFlow) ||
+		tx.TotalUsdFlow.Equal(current.TotalUsdFlow) && tx.TxHash < current.TxHash {
+		edges[tx.From][tx.To] = newHop(tx)
+	}
+}
+
+// shortestPaths returns up to limit simple paths of at most maxHops in order of length,
+// paths of the same length are ordered by their nodes
+func shortestPaths(edges map[string]map[string]Hop, source string, target string, maxHops int, limit int) []Path {
+	// hops left to the target from every address
+	toTarget := map[string]int{target: 0}
+	level := []string{target}
+	incoming := make(map[string][]string)
+	for from, tos := range edges {
+		for to := range tos {
+			incoming[to] = append(incoming[to], from)
+		}
+	}
+	for d := 1; len(level) > 0 && d <= maxHops; d++ {
+		next := []string{}
+		for _, addr := range level {
+			for _, from := range incoming[addr] {
+				if _, seen := toTarget[from]; !seen {
+					toTarget[from] = d
+					next = append(next, from)
+				}
+			}
+		}
+		level = next
+	}
+	shortest, reaches := toTarget[source]
+	if !reaches {
+		return []Path{}
+	}
+
+	neighbours := make(map[string][]string)
+	for from, tos := range edges {
+		for to := range tos {
+			neighbours[from] = append(neighbours[from], to)
+		}
+		sort.Strings(neighbours[from])
+	}
+
+	found := []Path{}
+	onPath := map[string]bool{source: true}
+	nodes := []string{source}
+	hops := []Hop{}
+	// paths of exactly length hops, one length at a time
+	var walk func(addr string, length int)
+	walk = func(addr string, length int) {
+		if len(found) >= limit {
+			return
+		}
+		left := length - len(hops)
+		for _, to := range neighbours[addr] {
+			if d, reaches := toTarget[to]; !reaches || d > left-1 || onPath[to] {
+				continue
+			}
+			hop := edges[addr][to]
+			nodes = append(nodes, to)
+			hops = append(hops, hop)
+			if to == target {
+				if len(hops) == length {
+					found = append(found, Path{Nodes: append([]string{}, nodes...), Hops: append([]Hop{}, hops...)})
+				}
+			} else {
+				onPath[to] = true
+				walk(to, length)
+				onPath[to] = false
+			}
+			nodes = nodes[:len(nodes)-1]
+			hops = hops[:len(hops)-1]
+			if len(found) >= limit {
+				return
+			}
+		}
+	}
+	for length := shortest; length <= maxHops && len(found) < limit; length++ {
+		walk(source, length)
+	}
+	return found
+}
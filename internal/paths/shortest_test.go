@@ -0,0 +1,146 @@
+package paths
+
+import (
+	"chain-traverser/internal/traverser"
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+// transfer is a tx of usd dollars, its hash is "from>to@block"
+func transfer(from string, to string, block int, usd int64) traverser.Tx {
+	return traverser.Tx{
+		TxHash:       from + ">" + to + "@" + strconv.Itoa(block),
+		From:         from,
+		To:           to,
+		Block:        block,
+		TotalUsdFlow: decimal.NewFromInt(usd),
+	}
+}
+
+// memExplorer serves a fixed set of txs, every address is expanded
+type memExplorer struct {
+	txs []traverser.Tx
+}
+
+func (e *memExplorer) Address(ctx context.Context, hash string, depth int) (*traverser.Addr, error) {
+	return &traverser.Addr{Hash: hash, Depth: depth, NeedTraverse: true}, nil
+}
+
+func (e *memExplorer) Transactions(ctx context.Context, hash string, flow string) ([]traverser.Tx, error) {
+	txs := []traverser.Tx{}
+	for _, tx := range e.txs {
+		if flow != traverser.FLOW_INPUT && tx.From == hash || flow != traverser.FLOW_OUTPUT && tx.To == hash {
+			txs = append(txs, tx)
+		}
+	}
+	return txs, nil
+}
+
+func (e *memExplorer) Stats() traverser.FetchStats { return traverser.FetchStats{} }
+
+// describe returns every path as its nodes joined by ">"
+func describe(paths []Path) []string {
+	result := []string{}
+	for _, path := range paths {
+		result = append(result, strings.Join(path.Nodes, ">"))
+	}
+	return result
+}
+
+func TestSearch(t *testing.T) {
+	// s reaches t in two hops through a, in three through b and c
+	txs := []traverser.Tx{
+		transfer("s", "a", 1, 10),
+		transfer("a", "t", 2, 10),
+		transfer("s", "b", 3, 100),
+		transfer("b", "c", 4, 100),
+		transfer("c", "t", 5, 100),
+	}
+	tests := []struct {
+		name          string
+		txs           []traverser.Tx
+		opts          SearchOptions
+		wantPaths     []string
+		wantTruncated string
+	}{
+		{
+			name:      "shortest path",
+			txs:       txs,
+			wantPaths: []string{"s>a>t"},
+		},
+		{
+			name:      "k shortest",
+			txs:       txs,
+			opts:      SearchOptions{K: 2},
+			wantPaths: []string{"s>a>t", "s>b>c>t"},
+		},
+		{
+			name:      "highest value",
+			txs:       txs,
+			opts:      SearchOptions{Rank: RANK_VALUE},
+			wantPaths: []string{"s>b>c>t"},
+		},
+		{
+			name:      "max hops",
+			txs:       txs,
+			opts:      SearchOptions{K: 2, MaxHops: 2},
+			wantPaths: []string{"s>a>t"},
+		},
+		{
+			name:      "no path",
+			txs:       []traverser.Tx{transfer("s", "a", 1, 10), transfer("t", "a", 2, 10)},
+			wantPaths: []string{},
+		},
+		{
+			name:          "node budget",
+			txs:           txs,
+			opts:          SearchOptions{NodeBudget: 1},
+			wantPaths:     []string{},
+			wantTruncated: TRUNCATED_NODE_BUDGET,
+		},
+		{
+			name:      "causality is not checked",
+			txs:       []traverser.Tx{transfer("s", "a", 9, 10), transfer("a", "t", 1, 10)},
+			wantPaths: []string{"s>a>t"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := search(context.Background(), "s", "t", tt.opts, &memExplorer{txs: tt.txs})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if paths := describe(result.Paths); !slices.Equal(paths, tt.wantPaths) {
+				t.Errorf("paths %v, want %v", paths, tt.wantPaths)
+			}
+			if result.Truncated != tt.wantTruncated {
+				t.Errorf("truncated %q, want %q", result.Truncated, tt.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestSearchCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result, err := search(ctx, "s", "t", SearchOptions{}, &memExplorer{txs: []traverser.Tx{transfer("s", "t", 1, 10)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Truncated != traverser.TRUNCATED_CANCELLED || result.Expanded != 0 {
+		t.Errorf("truncated %q after %d addresses, want %q after none", result.Truncated, result.Expanded, traverser.TRUNCATED_CANCELLED)
+	}
+}
+
+func TestSearchUnknownRank(t *testing.T) {
+	_, err := search(context.Background(), "s", "t", SearchOptions{Rank: "fame"}, &memExplorer{})
+	if !errors.Is(err, traverser.ErrInvalidQuery) {
+		t.Errorf("err %v, want %v", err, traverser.ErrInvalidQuery)
+	}
+}
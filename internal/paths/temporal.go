@@ -0,0 +1,165 @@
+// paths searches money trails between two addresses over collected transactions.
+package paths
+
+import (
+	"chain-traverser/internal/traverser"
+	"sort"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	DEFAULT_MAX_HOPS  = 8
+	DEFAULT_MAX_PATHS = 100
+)
+
+// Hop is a transaction moving funds one step along a path
+type Hop struct {
+	TxHash         string
+	From           string
+	To             string
+	Block          int
+	FlowByCurrency map[string]decimal.Decimal
+	TotalUsdFlow   decimal.Decimal
+}
+
+type Path struct {
+	Nodes []string // from the source to the target
+	Hops  []Hop    // Hops[i] moves funds from Nodes[i] to Nodes[i+1]
+}
+
+// Value is the USD amount the whole path can carry, the smallest of its hops
+func (p Path) Value() decimal.Decimal {
+	if len(p.Hops) == 0 {
+		return decimal.Zero
+	}
+	value := p.Hops[0].TotalUsdFlow
+	for _, hop := range p.Hops[1:] {
+		value = decimal.Min(value, hop.TotalUsdFlow)
+	}
+	return value
+}
+
+type TemporalOptions struct {
+	MaxHops  int // longest path, DEFAULT_MAX_HOPS if not set
+	MaxGap   int // most blocks between two hops, unbounded if not set
+	MaxPaths int // DEFAULT_MAX_PATHS if not set
+}
+
+func newHop(tx traverser.Tx) Hop {
+	return Hop{
+		TxHash:         tx.TxHash,
+		From:           tx.From,
+		To:             tx.To,
+		Block:          tx.Block,
+		FlowByCurrency: tx.FlowByCurrency,
+		TotalUsdFlow:   tx.TotalUsdFlow,
+	}
+}
+
+// outgoing returns hops leaving every address, ordered by counterparty and block
+func outgoing(txs []traverser.Tx) map[string][]Hop {
+	out := make(map[string][]Hop)
+	for _, tx := range txs {
+		if tx.From != tx.To {
+			out[tx.From] = append(out[tx.From], newHop(tx))
+		}
+	}
+	for _, hops := range out {
+		sort.Slice(hops, func(i, j int) bool {
+			if hops[i].To != hops[j].To {
+				return hops[i].To < hops[j].To
+			}
+			if hops[i].Block != hops[j].Block {
+				return hops[i].Block < hops[j].Block
+			}
+			return hops[i].TxHash < hops[j].TxHash
+		})
+	}
+	return out
+}
+
+// latestDepartures returns, for every address that can reach target, the latest
+// block it can send at and still get funds to target in time order
+func latestDepartures(out map[string][]Hop, target string) map[string]int {
+	latest := map[string]int{target: traverser.MAX_BLOCK}
+	for changed := true; changed; {
+		changed = false
+		for from, hops := range out {
+			for _, hop := range hops {
+				limit, reaches := latest[hop.To]
+				if !reaches || hop.Block > limit {
+					continue
+				}
+				if current, ok := latest[from]; !ok || hop.Block > current {
+					latest[from] = hop.Block
+					changed = true
+				}
+			}
+		}
+	}
+	return latest
+}
+
+// Temporal returns simple paths from source to target where every hop happens
+// at or after the block of the previous one, and at most opts.MaxGap blocks later.
+// Paths are found in a deterministic order, at most opts.MaxPaths of them.
+func Temporal(txs []traverser.Tx, source string, target string, opts TemporalOptions) []Path {
+	if opts.MaxHops <= 0 {
+		opts.MaxHops = DEFAULT_MAX_HOPS
+	}
+	if opts.MaxPaths <= 0 {
+		opts.MaxPaths = DEFAULT_MAX_PATHS
+	}
+	out := outgoing(txs)
+	latest := latestDepartures(out, target)
+	if _, reaches := latest[source]; !reaches || source == target {
+		return []Path{}
+	}
+
+	found := []Path{}
+	onPath := map[string]bool{source: true}
+	nodes := []string{source}
+	hops := []Hop{}
+
+	var walk func(addr string, block int)
+	walk = func(addr string, block int) {
+		if len(found) >= opts.MaxPaths || len(hops) >= opts.MaxHops {
+			return
+		}
+		var previous string
+		for _, hop := range out[addr] {
+			if hop.Block < block || onPath[hop.To] {
+				continue
+			}
+			if limit, reaches := latest[hop.To]; !reaches || hop.Block > limit {
+				continue
+			}
+			if len(hops) > 0 && opts.MaxGap > 0 && hop.Block-block > opts.MaxGap {
+				continue
+			}
+			// without a gap limit the earliest hop to a counterparty leaves the most options
+			if opts.MaxGap == 0 && hop.To == previous {
+				continue
+			}
+			previous = hop.To
+
+			nodes = append(nodes, hop.To)
+			hops = append(hops, hop)
+			if hop.To == target {
+				found = append(found, Path{Nodes: append([]string{}, nodes...), Hops: append([]Hop{}, hops...)})
+			} else {
+				onPath[hop.To] = true
+				walk(hop.To, hop.Block)
+				onPath[hop.To] = false
+			}
+			nodes = nodes[:len(nodes)-1]
+			hops = hops[:len(hops)-1]
+			if len(found) >= opts.MaxPaths {
+				return
+			}
+		}
+	}
+	walk(source, 0)
+	return found
+}
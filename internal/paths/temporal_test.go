@@ -0,0 +1,89 @@
+package paths
+
+import (
+	"chain-traverser/internal/traverser"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// describeHops returns every path as its nodes joined by ">" and the blocks of its hops
+func describeHops(paths []Path) []string {
+	result := []string{}
+	for _, path := range paths {
+		blocks := []string{}
+		for _, hop := range path.Hops {
+			blocks = append(blocks, strconv.Itoa(hop.Block))
+		}
+		result = append(result, strings.Join(path.Nodes, ">")+" "+strings.Join(blocks, ","))
+	}
+	return result
+}
+
+func TestTemporal(t *testing.T) {
+	tests := []struct {
+		name      string
+		txs       []traverser.Tx
+		opts      TemporalOptions
+		wantPaths []string
+	}{
+		{
+			name:      "hops in time order",
+			txs:       []traverser.Tx{transfer("s", "a", 1, 10), transfer("a", "t", 2, 10)},
+			wantPaths: []string{"s>a>t 1,2"},
+		},
+		{
+			name:      "funds can't leave before they arrive",
+			txs:       []traverser.Tx{transfer("s", "a", 5, 10), transfer("a", "t", 3, 10)},
+			wantPaths: []string{},
+		},
+		{
+			name:      "same block",
+			txs:       []traverser.Tx{transfer("s", "a", 4, 10), transfer("a", "t", 4, 10)},
+			wantPaths: []string{"s>a>t 4,4"},
+		},
+		{
+			name:      "earliest hop to a counterparty",
+			txs:       []traverser.Tx{transfer("s", "a", 1, 10), transfer("s", "a", 6, 10), transfer("a", "t", 3, 10)},
+			wantPaths: []string{"s>a>t 1,3"},
+		},
+		{
+			name:      "max gap",
+			txs:       []traverser.Tx{transfer("s", "a", 1, 10), transfer("a", "t", 10, 10), transfer("s", "b", 1, 10), transfer("b", "t", 4, 10)},
+			opts:      TemporalOptions{MaxGap: 5},
+			wantPaths: []string{"s>b>t 1,4"},
+		},
+		{
+			name:      "max gap picks a later hop to a counterparty",
+			txs:       []traverser.Tx{transfer("s", "a", 1, 10), transfer("s", "a", 6, 10), transfer("a", "t", 9, 10)},
+			opts:      TemporalOptions{MaxGap: 5},
+			wantPaths: []string{"s>a>t 6,9"},
+		},
+		{
+			name:      "max hops",
+			txs:       []traverser.Tx{transfer("s", "a", 1, 10), transfer("a", "b", 2, 10), transfer("b", "t", 3, 10), transfer("a", "t", 4, 10)},
+			opts:      TemporalOptions{MaxHops: 2},
+			wantPaths: []string{"s>a>t 1,4"},
+		},
+		{
+			name:      "max paths",
+			txs:       []traverser.Tx{transfer("s", "a", 1, 10), transfer("a", "t", 2, 10), transfer("s", "b", 1, 10), transfer("b", "t", 2, 10)},
+			opts:      TemporalOptions{MaxPaths: 1},
+			wantPaths: []string{"s>a>t 1,2"},
+		},
+		{
+			name:      "paths are simple",
+			txs:       []traverser.Tx{transfer("s", "a", 1, 10), transfer("a", "s", 2, 10), transfer("a", "t", 3, 10)},
+			wantPaths: []string{"s>a>t 1,3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths := describeHops(Temporal(tt.txs, "s", "t", tt.opts))
+			if !slices.Equal(paths, tt.wantPaths) {
+				t.Errorf("paths %v, want %v", paths, tt.wantPaths)
+			}
+		})
+	}
+}
@@ -0,0 +1,13 @@
+package storage
+
+import "errors"
+
+// errors returned by storage implementations, check them with errors.Is
+var (
+	// the key does not exist, e.g. an address was never seen by the indexer
+	ErrNotFound = errors.New("not found")
+	// storage can't be reached or failed to execute the command
+	ErrUnavailable = errors.New("storage unavailable")
+	// stored value can't be decoded
+	ErrCorrupt = errors.New("corrupt data")
+)
@@ -2,88 +2,143 @@ package redis
 
 import (
 	"chain-traverser/internal/storage"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 
+	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
 )
 
 // number of transactions per address
-func addrCntKey(addr *string) string {
-	return fmt.Sprintf("c%s:%s", DB_VERSION, *addr)
+func addrCntKey(version string, addr *string) string {
+	return fmt.Sprintf("c%s:%s", version, *addr)
 }
 
-func (client RedisClient) GetAddressTxNumber(addr *string) (int64, error) {
-	key := addrCntKey(addr)
+// GetAddressTxNumber returns storage.ErrNotFound for addresses the indexer never saw
+func (client *RedisClient) GetAddressTxNumber(ctx context.Context, addr *string) (int64, error) {
+	key := addrCntKey(client.Version(), addr)
 	val, err := client.redis.Get(ctx, key).Int64()
 	if err != nil {
-		// log.Err(err).Msg("Cant get counter")
+		return 0, wrapErr(err, "get counter "+*addr)
 	}
-	// log.Debug().Msgf("got counter %d for %s", val, *addr)
 	return val, nil
-
 }
 
-func (client RedisClient) UpdateCounters(transMap map[string]int64) {
-	for address, count := range transMap {
-		key := addrCntKey(&address)
-		err := client.redis.IncrBy(ctx, key, count)
-		if err.Err() != nil {
-			log.Err(err.Err()).Msg("Cant increment counter")
+// GetAddressTxNumbers reads counters of a batch of addresses in one round trip,
+// addresses the indexer never saw are left out of the result
+func (client *RedisClient) GetAddressTxNumbers(ctx context.Context, addrs []string) (map[string]int64, error) {
+	version := client.Version()
+	cmds, err := client.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i := range addrs {
+			pipe.Get(ctx, addrCntKey(version, &addrs[i]))
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, wrapErr(err, "get counters")
+	}
+	counters := make(map[string]int64, len(addrs))
+	for i, cmd := range cmds {
+		val, err := cmd.(*redis.StringCmd).Int64()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			log.Warn().Msgf("skip corrupt counter of %s: %s", addrs[i], err)
+			continue
 		}
+		counters[addrs[i]] = val
 	}
+	return counters, nil
 }
 
 // total amount of transactions per address
-func addrTxAmountKey(addr *string) string {
-	return fmt.Sprintf("a%s:%s", DB_VERSION, *addr)
+func addrTxAmountKey(version string, addr *string) string {
+	return fmt.Sprintf("a%s:%s", version, *addr)
 }
 
-func (client RedisClient) GetAddressTxAmount(addr *string) (int64, error) {
-	key := addrTxAmountKey(addr)
+func (client *RedisClient) GetAddressTxAmount(ctx context.Context, addr *string) (int64, error) {
+	key := addrTxAmountKey(client.Version(), addr)
 	val, err := client.redisAnalytics.Get(ctx, key).Int64()
 	if err != nil {
-		log.Err(err).Msg("Cant get total tx amount")
+		return 0, wrapErr(err, "get total tx amount "+*addr)
 	}
-	log.Debug().Msgf("got counter %d for %s", val, *addr)
 	return val, nil
 }
 
-func (client RedisClient) UpdateAddressTxAmount(transMap map[string]int64) {
-	// TODO! rewrite to pipeline
-	for address, count := range transMap {
-		key := addrTxAmountKey(&address)
-		err := client.redisAnalytics.IncrBy(ctx, key, count)
-		if err.Err() != nil {
-			log.Err(err.Err()).Msg("Cant increment total tx amount")
+func (client *RedisClient) UpdateAddressTxAmount(ctx context.Context, transMap map[string]int64) error {
+	_, err := client.redisAnalytics.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for address, count := range transMap {
+			pipe.IncrBy(ctx, addrTxAmountKey(client.Version(), &address), count)
 		}
+		return nil
+	})
+	if err != nil {
+		log.Err(err).Msg("Cant increment total tx amount")
+		return wrapErr(err, "increment total tx amount")
 	}
+	return nil
 }
 
 // addresses labels
-func addrLabels(addr *string) string {
-	return fmt.Sprintf("lbl%s:%s", DB_VERSION, *addr)
+func addrLabels(version string, addr *string) string {
+	return fmt.Sprintf("lbl%s:%s", version, *addr)
 }
 
-func (client RedisClient) GetAddressLabels(addr *string) (*storage.Labels, error) {
-	key := addrLabels(addr)
+func (client *RedisClient) GetAddressLabels(ctx context.Context, addr *string) (*storage.Labels, error) {
+	key := addrLabels(client.Version(), addr)
 	val, err := client.redisAnalytics.Get(ctx, key).Result()
 	if err != nil {
-		return nil, err
+		return nil, wrapErr(err, "get labels "+*addr)
 	}
 
 	var labels storage.Labels
 	err = json.Unmarshal([]byte(val), &labels)
 	if err != nil {
 		log.Err(err).Msg("Failed to unmarshal JSON")
-		return nil, err
+		return nil, fmt.Errorf("labels %s: %w: %w", *addr, storage.ErrCorrupt, err)
 	}
 
 	return &labels, nil
 }
 
+// GetAddressesLabels reads labels of addrs in one pipeline,
+// unlabelled addresses are left out and corrupt labels are skipped
+func (client *RedisClient) GetAddressesLabels(ctx context.Context, addrs []string) (map[string]*storage.Labels, error) {
+	version := client.Version()
+	cmds, err := client.redisAnalytics.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i := range addrs {
+			pipe.Get(ctx, addrLabels(version, &addrs[i]))
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, wrapErr(err, "get labels")
+	}
+	result := make(map[string]*storage.Labels, len(addrs))
+	for i, cmd := range cmds {
+		val, err := cmd.(*redis.StringCmd).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			log.Warn().Msgf("skip labels of %s: %s", addrs[i], err)
+			continue
+		}
+		var labels storage.Labels
+		if err := json.Unmarshal([]byte(val), &labels); err != nil {
+			log.Warn().Msgf("skip corrupt labels of %s: %s", addrs[i], err)
+			continue
+		}
+		result[addrs[i]] = &labels
+	}
+	return result, nil
+}
+
 // we update address's labels from python code
-// func (client RedisClient) UpdateAddressLabels(transMap map[string]int64) {
+// func (client *RedisClient) UpdateAddressLabels(ctx context.Context, transMap map[string]int64) {
 // 	// TODO! rewrite to pipeline
 // 	for address, count := range transMap {
 // 		key := addrLabels(&address)
@@ -1,83 +1,189 @@
 package redis
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math/big"
+	"strconv"
 
+	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
 )
 
-func blocksByAddrKey(addr *string) string {
-	return fmt.Sprintf("b%s:%s", DB_VERSION, *addr)
+func blocksByAddrKey(version string, addr *string) string {
+	return fmt.Sprintf("b%s:%s", version, *addr)
 }
 
-func (client RedisClient) GetAddressBlocks(addr *string) (*[]string, error) {
-	key := blocksByAddrKey(addr)
+func (client *RedisClient) GetAddressBlocks(ctx context.Context, addr *string) (*[]string, error) {
+	key := blocksByAddrKey(client.Version(), addr)
 	log.Debug().Msgf("getting blocks for %s", key)
 	slice, err := client.redis.LRange(ctx, key, 0, 1000).Result()
 	if err != nil {
 		log.Err(err).Msg("Cant get block list")
-		return nil, err
+		return nil, wrapErr(err, "get blocks of "+*addr)
 	}
 	// log.Debug().Msgf("blocks %s", slice)
 	log.Debug().Msgf("blocks %d", len(slice))
 	return &slice, nil
 }
 
-func (client RedisClient) AppendBlockNumbers(transMap map[string]int64, blockNumberInt *big.Int) {
-	// TODO! rewrite to pipeline
-	blockNumber := blockNumberInt.String()
-	for addr, _ := range transMap {
-		key := blocksByAddrKey(&addr)
-		err := client.redis.RPush(ctx, key, blockNumber)
-		if err.Err() != nil {
-			log.Err(err.Err()).Msg("Cant append block to wallet")
-		}
+func trxByBlockKey(version string, blockNumber *string) string {
+	return fmt.Sprintf("tx%s:%s", version, *blockNumber)
+}
+
+func (client *RedisClient) GetBlock(ctx context.Context, blockNumber *string) (*string, error) {
+	key := trxByBlockKey(client.Version(), blockNumber)
+	val, err := client.redis.Get(ctx, key).Result()
+	if err != nil {
+		return nil, wrapErr(err, "get block "+*blockNumber)
 	}
+	return &val, nil
 }
 
-func trxByBlockKey(blockNumber *string) string {
-	return fmt.Sprintf("tx%s:%s", DB_VERSION, *blockNumber)
+func lastBlockKey(version string) string {
+	return fmt.Sprintf("meta:last_block%s", version)
 }
 
-func (client RedisClient) GetBlock(blockNumber *string) (*string, error) {
-	key := trxByBlockKey(blockNumber)
-	val, err := client.redis.Get(ctx, key).Result()
+// GetLastBlockNumber returns storage.ErrNotFound if no block was committed yet
+func (client *RedisClient) GetLastBlockNumber(ctx context.Context) (*int64, error) {
+	key := lastBlockKey(client.Version())
+	val, err := client.redis.Get(ctx, key).Int64()
 	if err != nil {
-		log.Err(err).Msg("Cant get block")
-		return nil, err
+		return nil, wrapErr(err, "get last block number")
 	}
 	return &val, nil
 }
 
-func (client RedisClient) AddBlock(blockNumberInt *big.Int, blob *string) {
+// commitBlockScript writes a block blob, its timestamp, per address counters and
+// block lists together with the cursor. Blocks at or below the cursor are already
+// committed and are skipped, so re-processing a block never double-counts.
+//
+// KEYS: cursor, blob, block times, then a counter and a block list key per address
+// ARGV: block number, blob, block timestamp, then a transaction count per address
+var commitBlockScript = redis.NewScript(`
+local cursor = tonumber(redis.call("GET", KEYS[1]))
+local block = tonumber(ARGV[1])
+if cursor and cursor >= block then
+	return 0
+end
+redis.call("SET", KEYS[2], ARGV[2])
+redis.call("ZADD", KEYS[3], ARGV[3], ARGV[1])
+for i = 4, #ARGV do
+	local k = 4 + (i - 4) * 2
+	redis.call("INCRBY", KEYS[k], ARGV[i])
+	redis.call("RPUSH", KEYS[k + 1], ARGV[1])
+end
+redis.call("SET", KEYS[1], ARGV[1])
+return 1
+`)
+
+// CommitBlock atomically stores everything indexed from a block and moves the cursor to it.
+// Returns false if the block had already been committed.
+func (client *RedisClient) CommitBlock(ctx context.Context, blockNumberInt *big.Int, timestamp uint64, blob *string, transMap map[string]int64) (bool, error) {
+	version := client.Version()
 	blockNumber := blockNumberInt.String()
-	key := trxByBlockKey(&blockNumber)
-	// log.Info().Msgf("add block key=%s", key)
 
-	err := client.redis.Set(ctx, key, *blob, 0)
-	if err.Err() != nil {
-		log.Err(err.Err()).Msg("cant add block")
+	keys := make([]string, 0, 3+2*len(transMap))
+	args := make([]interface{}, 0, 3+len(transMap))
+	keys = append(keys, lastBlockKey(version), trxByBlockKey(version, &blockNumber), blockTimeKey(version))
+	args = append(args, blockNumber, *blob, timestamp)
+	for addr, count := range transMap {
+		keys = append(keys, addrCntKey(version, &addr), blocksByAddrKey(version, &addr))
+		args = append(args, count)
 	}
+
+	committed, err := commitBlockScript.Run(ctx, client.redis, keys, args...).Int()
+	if err != nil {
+		log.Err(err).Msgf("Cant commit block %s", blockNumber)
+		return false, wrapErr(err, "commit block "+blockNumber)
+	}
+	return committed == 1, nil
 }
 
-func (client RedisClient) GetLastBlockNumber() (*int64, error) {
-	key := fmt.Sprintf("meta:last_block%s", DB_VERSION)
-	val, err := client.redis.Get(ctx, key).Int64()
+// block timestamps, a sorted set of block numbers scored by unix time
+func blockTimeKey(version string) string {
+	return fmt.Sprintf("bt%s", version)
+}
+
+// GetBlockTimes returns unix timestamps of blockNumbers, blocks without one are left out
+func (client *RedisClient) GetBlockTimes(ctx context.Context, blockNumbers []string) (map[string]int64, error) {
+	key := blockTimeKey(client.Version())
+	cmds, err := client.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, number := range blockNumbers {
+			pipe.ZScore(ctx, key, number)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, wrapErr(err, "get block times")
+	}
+	times := make(map[string]int64, len(blockNumbers))
+	for i, cmd := range cmds {
+		if score, err := cmd.(*redis.FloatCmd).Result(); err == nil {
+			times[blockNumbers[i]] = int64(score)
+		}
+	}
+	return times, nil
+}
+
+// BlockAtTime returns the first block at or after timestamp, or with before set
+// the last block at or before it. storage.ErrNotFound if there is no such block.
+func (client *RedisClient) BlockAtTime(ctx context.Context, timestamp int64, before bool) (int64, error) {
+	key := blockTimeKey(client.Version())
+	rangeBy := &redis.ZRangeBy{Min: strconv.FormatInt(timestamp, 10), Max: "+inf", Count: 1}
+	cmd := client.redis.ZRangeByScore(ctx, key, rangeBy)
+	if before {
+		rangeBy = &redis.ZRangeBy{Min: "-inf", Max: strconv.FormatInt(timestamp, 10), Count: 1}
+		cmd = client.redis.ZRevRangeByScore(ctx, key, rangeBy)
+	}
+	blocks, err := cmd.Result()
 	if err != nil {
-		log.Err(err).Msg("Cant get last block number")
-		return nil, err
+		return 0, wrapErr(err, "get block at time")
 	}
-	return &val, nil
+	if len(blocks) == 0 {
+		return 0, wrapErr(redis.Nil, fmt.Sprintf("block at time %d", timestamp))
+	}
+	number, err := strconv.ParseInt(blocks[0], 10, 64)
+	return number, wrapErr(err, fmt.Sprintf("block at time %d", timestamp))
+}
+
+// GetBlocks reads a batch of blocks, blocks that are not indexed are left out of the result
+func (client *RedisClient) GetBlocks(ctx context.Context, blockNumbers []string) (map[string]string, error) {
+	version := client.Version()
+	keys := make([]string, len(blockNumbers))
+	for i := range blockNumbers {
+		keys[i] = trxByBlockKey(version, &blockNumbers[i])
+	}
+	blocks := make(map[string]string, len(blockNumbers))
+
+	vals, err := client.redis.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, wrapErr(err, "get blocks")
+	}
+	for i, val := range vals {
+		if blob, ok := val.(string); ok {
+			blocks[blockNumbers[i]] = blob
+		}
+	}
+	return blocks, nil
 }
 
-func (client RedisClient) UpdateLastBlockNumber(blockNumber *big.Int) error {
-	key := fmt.Sprintf("meta:last_block%s", DB_VERSION)
-	value := blockNumber.String()
-	err := client.redis.Set(ctx, key, value, 0)
-	if err.Err() != nil {
-		log.Err(err.Err()).Msg("UpdateLastBlockNumber")
-		return err.Err()
+// GetAddressesBlocks reads block lists of a batch of addresses in one round trip
+func (client *RedisClient) GetAddressesBlocks(ctx context.Context, addrs []string) (map[string][]string, error) {
+	version := client.Version()
+	cmds, err := client.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i := range addrs {
+			pipe.LRange(ctx, blocksByAddrKey(version, &addrs[i]), 0, 1000)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, wrapErr(err, "get blocks of addresses")
+	}
+	blocks := make(map[string][]string, len(addrs))
+	for i, cmd := range cmds {
+		blocks[addrs[i]] = cmd.(*redis.StringSliceCmd).Val()
 	}
-	return nil
+	return blocks, nil
 }
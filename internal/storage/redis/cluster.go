@@ -0,0 +1,45 @@
+package redis
+
+import (
+	"chain-traverser/internal/storage"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// members of a cluster node of a graph response, they expire with the response
+func clusterKey(version string, id string) string {
+	return fmt.Sprintf("cl%s:%s", version, id)
+}
+
+// SaveClusters stores members of every cluster id for ttl
+func (client *RedisClient) SaveClusters(ctx context.Context, clusters map[string][]string, ttl time.Duration) error {
+	version := client.Version()
+	_, err := client.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for id, members := range clusters {
+			value, err := json.Marshal(members)
+			if err != nil {
+				return err
+			}
+			pipe.Set(ctx, clusterKey(version, id), value, ttl)
+		}
+		return nil
+	})
+	return wrapErr(err, "save clusters")
+}
+
+// GetCluster returns storage.ErrNotFound if the cluster is unknown or expired
+func (client *RedisClient) GetCluster(ctx context.Context, id string) ([]string, error) {
+	val, err := client.redis.Get(ctx, clusterKey(client.Version(), id)).Result()
+	if err != nil {
+		return nil, wrapErr(err, "get cluster "+id)
+	}
+	var members []string
+	if err := json.Unmarshal([]byte(val), &members); err != nil {
+		return nil, fmt.Errorf("cluster %s: %w: %w", id, storage.ErrCorrupt, err)
+	}
+	return members, nil
+}
@@ -0,0 +1,216 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+type MigrateOptions struct {
+	From string
+	To   string
+	// first block to migrate if the target keyspace has no cursor yet
+	StartBlock int64
+	// last block to migrate, the source cursor if 0. Pass the cursor CopyKeys
+	// returned so blocks match the copied counters and block lists.
+	LastBlock int64
+	// blocks written per MULTI/EXEC
+	BatchSize int
+	// converts a block blob of the source version into the target format
+	ReencodeBlock func(blockNumber int64, blob string) (string, error)
+}
+
+// MigrateBlocks builds the target keyspace from the source one block by block.
+// Block blobs are re-encoded, block timestamps are copied. Per address counters and
+// block lists are copied by CopyKeys, they can't be rebuilt from the blobs: the
+// indexer also counts transfers it doesn't store. Every batch is written together
+// with the target cursor, so the migration can be interrupted and resumed, and
+// re-run later to catch up with blocks indexed meanwhile.
+func (client *RedisClient) MigrateBlocks(ctx context.Context, opts MigrateOptions) (int64, error) {
+	if opts.From == opts.To {
+		return 0, errors.New("source and target versions are the same")
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+
+	lastBlock, err := client.redis.Get(ctx, lastBlockKey(opts.From)).Int64()
+	if err != nil {
+		return 0, wrapErr(err, "source cursor")
+	}
+	if opts.LastBlock > 0 {
+		lastBlock = min(lastBlock, opts.LastBlock)
+	}
+	startBlock := opts.StartBlock
+	targetCursor, err := client.redis.Get(ctx, lastBlockKey(opts.To)).Int64()
+	if err == nil {
+		startBlock = targetCursor + 1
+	} else if !errors.Is(err, redis.Nil) {
+		return 0, wrapErr(err, "target cursor")
+	}
+	log.Info().Msgf("migrating blocks %d..%d from v%s to v%s", startBlock, lastBlock, opts.From, opts.To)
+
+	var migrated int64
+	for from := startBlock; from <= lastBlock; from += int64(opts.BatchSize) {
+		to := min(from+int64(opts.BatchSize)-1, lastBlock)
+		n, err := client.migrateBatch(ctx, from, to, opts)
+		if err != nil {
+			return migrated, fmt.Errorf("blocks %d..%d: %w", from, to, err)
+		}
+		migrated += n
+		if (to-startBlock+1)%10_000 < int64(opts.BatchSize) {
+			log.Info().Msgf("migrated up to block %d", to)
+		}
+	}
+	return migrated, nil
+}
+
+func (client *RedisClient) migrateBatch(ctx context.Context, from int64, to int64, opts MigrateOptions) (int64, error) {
+	keys := []string{}
+	for number := from; number <= to; number++ {
+		blockNumber := strconv.FormatInt(number, 10)
+		keys = append(keys, trxByBlockKey(opts.From, &blockNumber))
+	}
+	blobs, err := client.redis.MGet(ctx, keys...).Result()
+	if err != nil {
+		return 0, wrapErr(err, "get blocks")
+	}
+	// timestamps are not part of blobs, they are copied as is
+	timeCmds, err := client.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for number := from; number <= to; number++ {
+			pipe.ZScore(ctx, blockTimeKey(opts.From), strconv.FormatInt(number, 10))
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return 0, wrapErr(err, "get block times")
+	}
+
+	var migrated int64
+	_, err = client.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, raw := range blobs {
+			blob, ok := raw.(string)
+			if !ok {
+				// block was never indexed
+				continue
+			}
+			number := from + int64(i)
+			if opts.ReencodeBlock != nil {
+				blob, err = opts.ReencodeBlock(number, blob)
+				if err != nil {
+					return fmt.Errorf("reencode block %d: %w", number, err)
+				}
+			}
+			blockNumber := strconv.FormatInt(number, 10)
+			pipe.Set(ctx, trxByBlockKey(opts.To, &blockNumber), blob, 0)
+			if timestamp, err := timeCmds[i].(*redis.FloatCmd).Result(); err == nil {
+				pipe.ZAdd(ctx, blockTimeKey(opts.To), redis.Z{Score: timestamp, Member: blockNumber})
+			}
+			migrated++
+		}
+		pipe.Set(ctx, lastBlockKey(opts.To), strconv.FormatInt(to, 10), 0)
+		return nil
+	})
+	return migrated, err
+}
+
+// CopyKeys copies the keys which are not stored in block blobs (labels, total tx
+// amounts, prices, per address counters and block lists) from one keyspace version
+// to another, and returns the source cursor they match. Counters and block lists
+// are only consistent while the indexer is stopped, the copy fails if the source
+// cursor moves meanwhile.
+func (client *RedisClient) CopyKeys(ctx context.Context, from string, to string) (int, int64, error) {
+	if from == to {
+		return 0, 0, errors.New("source and target versions are the same")
+	}
+	cursor, err := client.redis.Get(ctx, lastBlockKey(from)).Int64()
+	if err != nil {
+		return 0, 0, wrapErr(err, "source cursor")
+	}
+	type keyspace struct {
+		db      redis.UniversalClient
+		pattern string
+		rename  func(key string) string
+	}
+	prefixed := func(prefix string) func(string) string {
+		return func(key string) string {
+			return prefix + to + strings.TrimPrefix(key, prefix+from)
+		}
+	}
+	keyspaces := []keyspace{
+		{client.redisAnalytics, "lbl" + from + ":*", prefixed("lbl")},
+		{client.redisAnalytics, "a" + from + ":*", prefixed("a")},
+		{client.redis, "c" + from + ":*", prefixed("c")},
+		{client.redis, "b" + from + ":*", prefixed("b")},
+		{client.redis, "*:price" + from + ":*", func(key string) string {
+			return strings.Replace(key, ":price"+from+":", ":price"+to+":", 1)
+		}},
+	}
+
+	copied := 0
+	for _, ks := range keyspaces {
+		iter := ks.db.Scan(ctx, 0, ks.pattern, 1000).Iterator()
+		batch := []string{}
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			err := copyBatch(ctx, ks.db, batch, ks.rename)
+			copied += len(batch)
+			batch = batch[:0]
+			return err
+		}
+		for iter.Next(ctx) {
+			batch = append(batch, iter.Val())
+			if len(batch) == 1000 {
+				if err := flush(); err != nil {
+					return copied, cursor, err
+				}
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return copied, cursor, err
+		}
+		if err := flush(); err != nil {
+			return copied, cursor, err
+		}
+		log.Info().Msgf("copied %s", ks.pattern)
+	}
+	moved, err := client.redis.Get(ctx, lastBlockKey(from)).Int64()
+	if err != nil {
+		return copied, cursor, wrapErr(err, "source cursor")
+	}
+	if moved != cursor {
+		return copied, cursor, fmt.Errorf("source cursor moved from %d to %d while copying, stop the indexer and run again", cursor, moved)
+	}
+	return copied, cursor, nil
+}
+
+func copyBatch(ctx context.Context, db redis.UniversalClient, keys []string, rename func(string) string) error {
+	cmds, err := db.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Dump(ctx, key)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	_, err = db.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, cmd := range cmds {
+			dump, err := cmd.(*redis.StringCmd).Result()
+			if err != nil {
+				// key expired or removed during the scan
+				continue
+			}
+			pipe.RestoreReplace(ctx, rename(keys[i]), 0, dump)
+		}
+		return nil
+	})
+	return err
+}
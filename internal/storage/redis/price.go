@@ -1,6 +1,8 @@
 package redis
 
 import (
+	"chain-traverser/internal/storage"
+	"context"
 	"fmt"
 	"strings"
 
@@ -8,31 +10,30 @@ import (
 	"github.com/shopspring/decimal"
 )
 
-func priceDataKey(timestamp int64, currency string) string {
+func priceDataKey(version string, timestamp int64, currency string) string {
 	currency = strings.ToLower(currency)
 
-	return fmt.Sprintf("%s:price%s:%d", currency, DB_VERSION, timestamp)
+	return fmt.Sprintf("%s:price%s:%d", currency, version, timestamp)
 }
 
-func (client *RedisClient) SetPriceData(timestamp int64, priceUSD, currency string) error {
-	key := priceDataKey(timestamp, currency)
+func (client *RedisClient) SetPriceData(ctx context.Context, timestamp int64, priceUSD, currency string) error {
+	key := priceDataKey(client.Version(), timestamp, currency)
 	err := client.redis.Set(ctx, key, priceUSD, 0)
 	// log.Info().Msgf("key %s", key)
 	if err.Err() != nil {
 		log.Err(err.Err()).Msg("SetPriceData")
-		return err.Err()
+		return wrapErr(err.Err(), "set price")
 	}
 
 	return nil
 }
 
-func (client *RedisClient) GetPrice(timestamp int64, currency string) (*decimal.Decimal, error) {
-	key := priceDataKey(timestamp, currency)
+func (client *RedisClient) GetPrice(ctx context.Context, timestamp int64, currency string) (*decimal.Decimal, error) {
+	key := priceDataKey(client.Version(), timestamp, currency)
 
 	val, err := client.redis.Get(ctx, key).Result()
 	if err != nil {
-		log.Err(err).Msgf("GetPrice fetching %d %s", timestamp, currency)
-		return nil, err
+		return nil, wrapErr(err, fmt.Sprintf("get price %s %d", currency, timestamp))
 	}
 
 	// log.Info().Msgf("getch key %s |%s|", key, val)
@@ -41,7 +42,7 @@ func (client *RedisClient) GetPrice(timestamp int64, currency string) (*decimal.
 
 	if err != nil {
 		log.Err(err).Msg("GetPrice type casting")
-		return nil, err
+		return nil, fmt.Errorf("price %s %d: %w: %w", currency, timestamp, storage.ErrCorrupt, err)
 	}
 
 	return &price, nil
@@ -1,21 +1,22 @@
 package redis
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/rs/zerolog/log"
 )
 
-func pubAddrKey() string {
-	return fmt.Sprintf("aq%s", DB_VERSION)
+func pubAddrKey(version string) string {
+	return fmt.Sprintf("aq%s", version)
 }
 
-func (client RedisClient) SendAddress(addr string) {
-	key := pubAddrKey()
+func (client *RedisClient) SendAddress(ctx context.Context, addr string) error {
+	key := pubAddrKey(client.Version())
 	err := client.redisQueue.LPush(ctx, key, addr)
 	if err.Err() != nil {
 		log.Err(err.Err()).Msg("Cant publish address")
+		return wrapErr(err.Err(), "publish address")
 	}
-	// log.Debug().Msgf("send addr %s", addr)
-
+	return nil
 }
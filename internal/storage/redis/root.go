@@ -2,37 +2,201 @@ package redis
 
 import (
 	"chain-traverser/internal/config"
+	"chain-traverser/internal/storage"
 	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
 
 	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
 )
 
-var ctx = context.Background()
+// keyspace version used when REDIS_DB_VERSION is not pinned
+// and no migration has switched the active version yet
+const DEFAULT_DB_VERSION = "1"
 
-const DB_VERSION = "1"
+const (
+	MODE_STANDALONE = "standalone"
+	MODE_SENTINEL   = "sentinel"
+)
 
 type RedisClient struct {
-	redis          *redis.Client
-	redisAnalytics *redis.Client
-	redisQueue     *redis.Client
-}
-
-func NewClient(config *config.RedisConfig) *RedisClient {
-	redisMain := redis.NewClient(&redis.Options{
-		Addr:     config.Address,
-		Password: config.Password,
-		DB:       config.MAIN_DB,
-	})
-	redisAnalytics := redis.NewClient(&redis.Options{
-		Addr:     config.Address,
-		Password: config.Password,
-		DB:       config.ANALYTICS_DB,
-	})
-	redisQueue := redis.NewClient(&redis.Options{
-		Addr:     config.Address,
-		Password: config.Password,
-		DB:       config.QUEUE_DB,
-	})
-	res := RedisClient{redis: redisMain, redisAnalytics: redisAnalytics, redisQueue: redisQueue}
-	return &res
+	redis          redis.UniversalClient
+	redisAnalytics redis.UniversalClient
+	redisQueue     redis.UniversalClient
+	// every key is prefixed with the keyspace version,
+	// see cmd/migrator for building a new version from the old one
+	version *atomic.Pointer[string]
+	pinned  bool
+}
+
+func newUniversalClient(config *config.RedisConfig, db int) redis.UniversalClient {
+	addrs := config.Addresses
+	if len(addrs) == 0 {
+		addrs = []string{config.Address}
+	}
+	opts := &redis.UniversalOptions{
+		Addrs:        addrs,
+		Password:     config.Password,
+		DB:           db,
+		PoolSize:     config.PoolSize,
+		DialTimeout:  config.Timeout,
+		ReadTimeout:  config.Timeout,
+		WriteTimeout: config.Timeout,
+	}
+	switch config.Mode {
+	case MODE_SENTINEL:
+		opts.MasterName = config.MasterName
+		return redis.NewFailoverClient(opts.Failover())
+	default:
+		return redis.NewClient(opts.Simple())
+	}
+}
+
+// NewClient creates a client meant to be shared by the whole process, Close it on shutdown.
+// Databases with the same number share one connection pool. Cluster mode is not
+// supported, block commits and migrations run multi-key scripts and transactions.
+func NewClient(ctx context.Context, config *config.RedisConfig) (*RedisClient, error) {
+	switch config.Mode {
+	case MODE_STANDALONE, MODE_SENTINEL:
+	default:
+		return nil, fmt.Errorf("unknown redis mode %q", config.Mode)
+	}
+	if config.Mode == MODE_SENTINEL && config.MasterName == "" {
+		return nil, errors.New("REDIS_SENTINEL_MASTER is required in sentinel mode")
+	}
+
+	pools := make(map[int]redis.UniversalClient)
+	pool := func(db int) redis.UniversalClient {
+		if _, exists := pools[db]; !exists {
+			pools[db] = newUniversalClient(config, db)
+		}
+		return pools[db]
+	}
+	res := RedisClient{
+		redis:          pool(config.MAIN_DB),
+		redisAnalytics: pool(config.ANALYTICS_DB),
+		redisQueue:     pool(config.QUEUE_DB),
+		version:        &atomic.Pointer[string]{},
+		pinned:         config.DB_VERSION != "",
+	}
+	if res.pinned {
+		res.version.Store(&config.DB_VERSION)
+		return &res, nil
+	}
+	if err := res.RefreshVersion(ctx); err != nil {
+		res.Close()
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Close releases all connection pools
+func (client *RedisClient) Close() error {
+	var err error
+	closed := make(map[redis.UniversalClient]bool)
+	for _, c := range []redis.UniversalClient{client.redis, client.redisAnalytics, client.redisQueue} {
+		if closed[c] {
+			continue
+		}
+		closed[c] = true
+		if cErr := c.Close(); cErr != nil {
+			err = cErr
+		}
+	}
+	return err
+}
+
+// Version returns the keyspace version the client reads and writes
+func (client *RedisClient) Version() string {
+	return *client.version.Load()
+}
+
+// RefreshVersion re-reads the active keyspace version switched by the migrator.
+// It does nothing when the version is pinned with REDIS_DB_VERSION.
+func (client *RedisClient) RefreshVersion(ctx context.Context) error {
+	if client.pinned {
+		return nil
+	}
+	version, err := client.GetActiveVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if version == "" {
+		version = DEFAULT_DB_VERSION
+	}
+	if prev := client.version.Load(); prev != nil && *prev != version {
+		log.Info().Msgf("keyspace version switched %s -> %s", *prev, version)
+	}
+	client.version.Store(&version)
+	return nil
+}
+
+// wrapErr maps redis client errors to the storage errors
+func wrapErr(err error, msg string) error {
+	var numErr *strconv.NumError
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, redis.Nil):
+		return fmt.Errorf("%s: %w", msg, storage.ErrNotFound)
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		// the caller gave up, storage is fine
+		return fmt.Errorf("%s: %w", msg, err)
+	case errors.As(err, &numErr):
+		return fmt.Errorf("%s: %w: %w", msg, storage.ErrCorrupt, err)
+	default:
+		return fmt.Errorf("%s: %w: %w", msg, storage.ErrUnavailable, err)
+	}
+}
+
+// active keyspace version, the only unversioned key
+func activeVersionKey() string {
+	return "meta:db_version"
+}
+
+// GetActiveVersion returns the version readers are switched to, empty if none
+func (client *RedisClient) GetActiveVersion(ctx context.Context) (string, error) {
+	val, err := client.redis.Get(ctx, activeVersionKey()).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	if err != nil {
+		log.Err(err).Msg("Cant get active db version")
+		return "", wrapErr(err, "get active version")
+	}
+	return val, nil
+}
+
+// SetActiveVersion atomically switches readers to another keyspace version
+func (client *RedisClient) SetActiveVersion(ctx context.Context, version string) error {
+	err := client.redis.Set(ctx, activeVersionKey(), version, 0).Err()
+	if err != nil {
+		log.Err(err).Msg("Cant set active db version")
+		return wrapErr(err, "set active version")
+	}
+	return nil
+}
+
+// WatchVersion keeps a long-lived client on the active keyspace version
+// until the context is cancelled
+func (client *RedisClient) WatchVersion(ctx context.Context, interval time.Duration) {
+	if client.pinned {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := client.RefreshVersion(ctx); err != nil {
+				log.Err(err).Msg("Cant refresh db version")
+			}
+		}
+	}
 }
@@ -0,0 +1,303 @@
+package redis
+
+import (
+	"chain-traverser/internal/storage/snapshot"
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+const SNAPSHOT_BATCH_SIZE = 500
+
+// ExportSnapshot writes blocks fromBlock..toBlock with their timestamps, the block lists, counters and
+// labels of every address taking part in them, and all the prices. Blobs name the token recipient of an
+// ERC20 transfer, not the token contract the indexer counts, so the contracts to export are passed in.
+// Block lists are cut to the range, counters and amounts are the all-time values of the source.
+func (client *RedisClient) ExportSnapshot(ctx context.Context, w *snapshot.Writer, fromBlock int64, toBlock int64, contracts []string) error {
+	version := client.Version()
+	// true for addresses named by the blobs, false for contracts that may be idle in the range
+	addrs := make(map[string]bool)
+	for _, contract := range contracts {
+		addrs[contract] = false
+	}
+
+	for from := fromBlock; from <= toBlock; from += SNAPSHOT_BATCH_SIZE {
+		to := min(from+SNAPSHOT_BATCH_SIZE-1, toBlock)
+		keys := []string{}
+		for number := from; number <= to; number++ {
+			blockNumber := strconv.FormatInt(number, 10)
+			keys = append(keys, trxByBlockKey(version, &blockNumber))
+		}
+		blobs, err := client.redis.MGet(ctx, keys...).Result()
+		if err != nil {
+			return wrapErr(err, "get blocks")
+		}
+		numbers := make([]string, 0, len(blobs))
+		for number := from; number <= to; number++ {
+			numbers = append(numbers, strconv.FormatInt(number, 10))
+		}
+		times, err := client.GetBlockTimes(ctx, numbers)
+		if err != nil {
+			return err
+		}
+		for i, raw := range blobs {
+			blob, ok := raw.(string)
+			if !ok {
+				continue
+			}
+			if err := w.Write(snapshot.Record{Kind: snapshot.KIND_BLOCK, Block: from + int64(i), Value: blob}); err != nil {
+				return err
+			}
+			for _, tx := range strings.Split(blob, "\n") {
+				vals := strings.Split(tx, ";")
+				if len(vals) < 3 {
+					continue
+				}
+				addrs[vals[0]] = true
+				addrs[vals[2]] = true
+			}
+			if timestamp, ok := times[numbers[i]]; ok {
+				if err := w.Write(snapshot.Record{Kind: snapshot.KIND_TIME, Block: from + int64(i), Timestamp: timestamp}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	log.Info().Msgf("exported blocks %d..%d, %d addresses", fromBlock, toBlock, len(addrs))
+
+	sorted := make([]string, 0, len(addrs))
+	for addr := range addrs {
+		sorted = append(sorted, addr)
+	}
+	sort.Strings(sorted)
+	for i := 0; i < len(sorted); i += SNAPSHOT_BATCH_SIZE {
+		batch := sorted[i:min(i+SNAPSHOT_BATCH_SIZE, len(sorted))]
+		if err := client.exportAddresses(ctx, w, batch, addrs, fromBlock, toBlock); err != nil {
+			return err
+		}
+	}
+
+	iter := client.redis.Scan(ctx, 0, "*:price"+version+":*", 1000).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		parts := strings.Split(key, ":")
+		timestamp, err := strconv.ParseInt(parts[len(parts)-1], 10, 64)
+		if err != nil {
+			continue
+		}
+		price, err := client.redis.Get(ctx, key).Result()
+		if err != nil {
+			return wrapErr(err, "get price")
+		}
+		err = w.Write(snapshot.Record{Kind: snapshot.KIND_PRICE, Currency: parts[0], Timestamp: timestamp, Value: price})
+		if err != nil {
+			return err
+		}
+	}
+	return wrapErr(iter.Err(), "scan prices")
+}
+
+func (client *RedisClient) exportAddresses(ctx context.Context, w *snapshot.Writer, addrs []string, inBlobs map[string]bool, fromBlock int64, toBlock int64) error {
+	version := client.Version()
+	mainCmds, err := client.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, addr := range addrs {
+			pipe.LRange(ctx, blocksByAddrKey(version, &addr), 0, -1)
+			pipe.Get(ctx, addrCntKey(version, &addr))
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return wrapErr(err, "export addresses")
+	}
+	analyticsCmds, err := client.redisAnalytics.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, addr := range addrs {
+			pipe.Get(ctx, addrTxAmountKey(version, &addr))
+			pipe.Get(ctx, addrLabels(version, &addr))
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return wrapErr(err, "export addresses")
+	}
+
+	for i, addr := range addrs {
+		blocks := []string{}
+		for _, block := range mainCmds[2*i].(*redis.StringSliceCmd).Val() {
+			number, err := strconv.ParseInt(block, 10, 64)
+			if err == nil && number >= fromBlock && number <= toBlock {
+				blocks = append(blocks, block)
+			}
+		}
+		if len(blocks) == 0 && !inBlobs[addr] {
+			// a contract idle in the range
+			continue
+		}
+		records := []snapshot.Record{}
+		// token recipients are in the blobs but not counted by the indexer
+		if len(blocks) > 0 {
+			records = append(records, snapshot.Record{Kind: snapshot.KIND_BLOCKS, Address: addr, Blocks: blocks})
+		}
+		values := map[string]redis.Cmder{
+			snapshot.KIND_COUNTER: mainCmds[2*i+1],
+			snapshot.KIND_AMOUNT:  analyticsCmds[2*i],
+			snapshot.KIND_LABELS:  analyticsCmds[2*i+1],
+		}
+		for _, kind := range []string{snapshot.KIND_COUNTER, snapshot.KIND_AMOUNT, snapshot.KIND_LABELS} {
+			val, err := values[kind].(*redis.StringCmd).Result()
+			if err == nil {
+				records = append(records, snapshot.Record{Kind: kind, Address: addr, Value: val})
+			}
+		}
+		for _, record := range records {
+			if err := w.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ImportSnapshot loads a snapshot into the current keyspace version.
+// Importing is idempotent: block lists are merged, counters keep the larger value.
+// The checksum is verified at the end only, call snapshot.Verify first.
+func (client *RedisClient) ImportSnapshot(ctx context.Context, r io.Reader) (*snapshot.Header, int64, error) {
+	var imported int64
+	batch := []snapshot.Record{}
+	flush := func() error {
+		if err := client.importBatch(ctx, batch); err != nil {
+			return err
+		}
+		imported += int64(len(batch))
+		batch = batch[:0]
+		return nil
+	}
+	header, err := snapshot.Read(r, func(record snapshot.Record) error {
+		batch = append(batch, record)
+		if len(batch) == SNAPSHOT_BATCH_SIZE {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, imported, err
+	}
+	if err := flush(); err != nil {
+		return nil, imported, err
+	}
+	return header, imported, nil
+}
+
+func (client *RedisClient) importBatch(ctx context.Context, records []snapshot.Record) error {
+	version := client.Version()
+
+	// current values to merge with
+	current := make(map[int]redis.Cmder)
+	_, err := client.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, record := range records {
+			switch record.Kind {
+			case snapshot.KIND_BLOCKS:
+				current[i] = pipe.LRange(ctx, blocksByAddrKey(version, &record.Address), 0, -1)
+			case snapshot.KIND_COUNTER:
+				current[i] = pipe.Get(ctx, addrCntKey(version, &record.Address))
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return wrapErr(err, "import")
+	}
+	_, err = client.redisAnalytics.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, record := range records {
+			if record.Kind == snapshot.KIND_AMOUNT {
+				current[i] = pipe.Get(ctx, addrTxAmountKey(version, &record.Address))
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return wrapErr(err, "import")
+	}
+
+	larger := func(i int, value string) bool {
+		imported, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return false
+		}
+		existing, err := current[i].(*redis.StringCmd).Int64()
+		return err != nil || imported > existing
+	}
+
+	_, err = client.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, record := range records {
+			switch record.Kind {
+			case snapshot.KIND_BLOCK:
+				blockNumber := strconv.FormatInt(record.Block, 10)
+				pipe.Set(ctx, trxByBlockKey(version, &blockNumber), record.Value, 0)
+			case snapshot.KIND_TIME:
+				pipe.ZAdd(ctx, blockTimeKey(version), redis.Z{Score: float64(record.Timestamp), Member: strconv.FormatInt(record.Block, 10)})
+			case snapshot.KIND_BLOCKS:
+				blocks := mergeBlocks(current[i].(*redis.StringSliceCmd).Val(), record.Blocks)
+				if len(blocks) == 0 {
+					continue
+				}
+				key := blocksByAddrKey(version, &record.Address)
+				pipe.Del(ctx, key)
+				pipe.RPush(ctx, key, blocks)
+			case snapshot.KIND_COUNTER:
+				if larger(i, record.Value) {
+					pipe.Set(ctx, addrCntKey(version, &record.Address), record.Value, 0)
+				}
+			case snapshot.KIND_PRICE:
+				pipe.Set(ctx, priceDataKey(version, record.Timestamp, record.Currency), record.Value, 0)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return wrapErr(err, "import")
+	}
+
+	_, err = client.redisAnalytics.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, record := range records {
+			switch record.Kind {
+			case snapshot.KIND_AMOUNT:
+				if larger(i, record.Value) {
+					pipe.Set(ctx, addrTxAmountKey(version, &record.Address), record.Value, 0)
+				}
+			case snapshot.KIND_LABELS:
+				pipe.Set(ctx, addrLabels(version, &record.Address), record.Value, 0)
+			}
+		}
+		return nil
+	})
+	return wrapErr(err, "import")
+}
+
+// unique block numbers in ascending order, the way the indexer appends them
+func mergeBlocks(existing []string, imported []string) []string {
+	numbers := []int64{}
+	for _, block := range append(existing, imported...) {
+		number, err := strconv.ParseInt(block, 10, 64)
+		if err != nil {
+			log.Warn().Msgf("skip invalid block number %q", block)
+			continue
+		}
+		numbers = append(numbers, number)
+	}
+	slices.Sort(numbers)
+	numbers = slices.Compact(numbers)
+
+	blocks := make([]string, len(numbers))
+	for i, number := range numbers {
+		blocks[i] = strconv.FormatInt(number, 10)
+	}
+	return blocks
+}
@@ -0,0 +1,163 @@
+// snapshot is a portable file format for a block range of the indexed dataset.
+//
+// A snapshot is a gzip compressed stream of JSON lines: a header, the records
+// and a trailer holding the number of records and the sha256 of all the lines before it.
+package snapshot
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+)
+
+const FORMAT = 1
+
+const (
+	KIND_BLOCK   = "block"   // block blob
+	KIND_TIME    = "time"    // timestamp of a block
+	KIND_BLOCKS  = "blocks"  // blocks of an address within the range
+	KIND_COUNTER = "counter" // number of transactions of an address
+	KIND_AMOUNT  = "amount"  // total tx amount of an address
+	KIND_LABELS  = "labels"  // labels of an address, raw json
+	KIND_PRICE   = "price"   // daily price of a currency
+	KIND_TRAILER = "trailer"
+)
+
+var ErrChecksum = errors.New("snapshot checksum mismatch")
+
+type Header struct {
+	Format    int       `json:"format"`
+	Version   string    `json:"version"` // keyspace version the data was exported from
+	FromBlock int64     `json:"from_block"`
+	ToBlock   int64     `json:"to_block"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Record struct {
+	Kind      string   `json:"kind"`
+	Block     int64    `json:"block,omitempty"`
+	Address   string   `json:"address,omitempty"`
+	Currency  string   `json:"currency,omitempty"`
+	Timestamp int64    `json:"timestamp,omitempty"`
+	Blocks    []string `json:"blocks,omitempty"`
+	Value     string   `json:"value,omitempty"`
+	// trailer only
+	Count    int64  `json:"count,omitempty"`
+	Checksum string `json:"checksum,omitempty"`
+}
+
+type Writer struct {
+	gz    *gzip.Writer
+	hash  hash.Hash
+	count int64
+}
+
+func NewWriter(w io.Writer, header Header) (*Writer, error) {
+	header.Format = FORMAT
+	sw := &Writer{gz: gzip.NewWriter(w), hash: sha256.New()}
+	if err := sw.writeLine(header); err != nil {
+		return nil, err
+	}
+	return sw, nil
+}
+
+func (w *Writer) writeLine(v any) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	w.hash.Write(line)
+	_, err = w.gz.Write(line)
+	return err
+}
+
+func (w *Writer) Write(record Record) error {
+	w.count++
+	return w.writeLine(record)
+}
+
+// Count returns the number of records written so far
+func (w *Writer) Count() int64 {
+	return w.count
+}
+
+// Close writes the trailer and flushes the stream, the underlying writer stays open
+func (w *Writer) Close() error {
+	trailer := Record{Kind: KIND_TRAILER, Count: w.count, Checksum: hex.EncodeToString(w.hash.Sum(nil))}
+	line, err := json.Marshal(trailer)
+	if err != nil {
+		return err
+	}
+	if _, err := w.gz.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return w.gz.Close()
+}
+
+// Read calls fn for every record of the snapshot. The checksum can only be
+// checked at the end of the stream, use Verify before applying the records.
+func Read(r io.Reader, fn func(Record) error) (*Header, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	scanner := bufio.NewScanner(gz)
+	// block blobs of busy blocks are large
+	scanner.Buffer(make([]byte, 1024*1024), 256*1024*1024)
+	hash := sha256.New()
+
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("snapshot header: %w", errors.Join(scanner.Err(), io.ErrUnexpectedEOF))
+	}
+	var header Header
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return nil, fmt.Errorf("snapshot header: %w", err)
+	}
+	if header.Format != FORMAT {
+		return nil, fmt.Errorf("unsupported snapshot format %d", header.Format)
+	}
+	hash.Write(scanner.Bytes())
+	hash.Write([]byte{'\n'})
+
+	var count int64
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("snapshot record %d: %w", count+1, err)
+		}
+		if record.Kind == KIND_TRAILER {
+			checksum := hex.EncodeToString(hash.Sum(nil))
+			if record.Checksum != checksum || record.Count != count {
+				return nil, ErrChecksum
+			}
+			return &header, nil
+		}
+		hash.Write(scanner.Bytes())
+		hash.Write([]byte{'\n'})
+		count++
+		if fn != nil {
+			if err := fn(record); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("snapshot trailer: %w", io.ErrUnexpectedEOF)
+}
+
+// Verify reads the whole snapshot and checks its checksum
+func Verify(r io.Reader) (*Header, error) {
+	return Read(r, nil)
+}
@@ -0,0 +1,286 @@
+// taint propagates "dirty" funds of a source address forward through the
+// transaction graph, in chronological order.
+//
+// Only flows inside the traced graph are known: the source is assumed to hold
+// enough tainted funds for everything it sends. Under haircut and FIFO, funds an
+// address sends beyond what it received in the graph come from outside and are
+// clean. Poison ignores balances, so once an address received tainted funds
+// everything it sends is tainted, outside funds included.
+package taint
+
+import (
+	"chain-traverser/internal/storage/redis"
+	"chain-traverser/internal/traverser"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// every output of an address after it received tainted funds is fully tainted
+	MODEL_POISON = "poison"
+	// outputs carry the tainted share of the address balance
+	MODEL_HAIRCUT = "haircut"
+	// outputs spend received funds first in first out
+	MODEL_FIFO = "fifo"
+)
+
+var ErrUnknownModel = errors.New("unknown taint model")
+
+// AddressTaint holds tainted amounts of an address in one currency
+type AddressTaint struct {
+	Received decimal.Decimal // tainted funds received in total
+	Held     decimal.Decimal // tainted funds left after the last traced tx
+}
+
+type Result struct {
+	Model     string
+	Source    string
+	FromBlock int
+	// address -> currency -> taint
+	Addresses map[string]map[string]*AddressTaint
+	// tx hash -> currency -> tainted amount moved by the tx
+	Txs map[string]map[string]decimal.Decimal
+}
+
+// lot is a part of an address balance
+type lot struct {
+	amount decimal.Decimal
+	taint  decimal.Decimal // tainted part of the amount
+}
+
+// wallet is the balance of an address in one currency as the model sees it
+type wallet struct {
+	balance  decimal.Decimal
+	taint    decimal.Decimal
+	lots     []lot // FIFO only
+	poisoned bool  // poison only
+}
+
+// receive adds amount with tainted part to the wallet
+func (w *wallet) receive(model string, amount decimal.Decimal, tainted decimal.Decimal) {
+	w.balance = w.balance.Add(amount)
+	w.taint = w.taint.Add(tainted)
+	switch model {
+	case MODEL_POISON:
+		// whatever a poisoned address holds is tainted
+		w.poisoned = w.poisoned || tainted.IsPositive()
+		if w.poisoned {
+			w.taint = w.balance
+		}
+	case MODEL_FIFO:
+		w.lots = append(w.lots, lot{amount: amount, taint: tainted})
+	}
+}
+
+// send removes amount from the wallet and returns its tainted part
+func (w *wallet) send(model string, amount decimal.Decimal) decimal.Decimal {
+	var tainted decimal.Decimal
+	switch model {
+	case MODEL_POISON:
+		if w.poisoned {
+			tainted = amount
+		}
+	case MODEL_HAIRCUT:
+		// funds beyond the known balance come from outside the graph and are clean
+		balance := decimal.Max(w.balance, amount)
+		if balance.IsPositive() {
+			tainted = w.taint.Mul(amount).Div(balance)
+		}
+	case MODEL_FIFO:
+		left := amount
+		for left.IsPositive() && len(w.lots) > 0 {
+			head := &w.lots[0]
+			spent := decimal.Min(left, head.amount)
+			if head.amount.IsPositive() {
+				part := head.taint.Mul(spent).Div(head.amount)
+				tainted = tainted.Add(part)
+				head.taint = head.taint.Sub(part)
+			}
+			head.amount = head.amount.Sub(spent)
+			left = left.Sub(spent)
+			if !head.amount.IsPositive() {
+				w.lots = w.lots[1:]
+			}
+		}
+	}
+	tainted = decimal.Min(tainted, amount)
+	w.balance = decimal.Max(w.balance.Sub(amount), decimal.Zero)
+	w.taint = decimal.Max(w.taint.Sub(tainted), decimal.Zero)
+	if w.poisoned {
+		w.taint = w.balance
+	}
+	return tainted
+}
+
+// Propagate spreads the taint of source over txs from fromBlock on.
+// Txs are replayed in block order, so the result doesn't depend on their order.
+func Propagate(source string, fromBlock int, txs []traverser.Tx, model string) (*Result, error) {
+	if err := checkModel(model); err != nil {
+		return nil, err
+	}
+	ordered := make([]traverser.Tx, 0, len(txs))
+	for _, tx := range txs {
+		if tx.Block >= fromBlock {
+			ordered = append(ordered, tx)
+		}
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		if ordered[i].Block != ordered[j].Block {
+			return ordered[i].Block < ordered[j].Block
+		}
+		if ordered[i].Index != ordered[j].Index {
+			return ordered[i].Index < ordered[j].Index
+		}
+		return ordered[i].TxHash < ordered[j].TxHash
+	})
+
+	result := &Result{
+		Model:     model,
+		Source:    source,
+		FromBlock: fromBlock,
+		Addresses: make(map[string]map[string]*AddressTaint),
+		Txs:       make(map[string]map[string]decimal.Decimal),
+	}
+	// address -> currency -> wallet
+	wallets := make(map[string]map[string]*wallet)
+	walletOf := func(addr string, currency string) *wallet {
+		if wallets[addr] == nil {
+			wallets[addr] = make(map[string]*wallet)
+		}
+		if wallets[addr][currency] == nil {
+			wallets[addr][currency] = &wallet{}
+		}
+		return wallets[addr][currency]
+	}
+
+	for _, tx := range ordered {
+		for currency, amount := range tx.FlowByCurrency {
+			if !amount.IsPositive() {
+				continue
+			}
+			var tainted decimal.Decimal
+			if tx.From == source {
+				tainted = amount
+			} else {
+				tainted = walletOf(tx.From, currency).send(model, amount)
+			}
+			if tx.To != source {
+				walletOf(tx.To, currency).receive(model, amount, tainted)
+			}
+			if !tainted.IsPositive() {
+				continue
+			}
+			if result.Txs[tx.TxHash] == nil {
+				result.Txs[tx.TxHash] = make(map[string]decimal.Decimal)
+			}
+			result.Txs[tx.TxHash][currency] = tainted
+			if tx.To != source {
+				taint := result.address(tx.To, currency)
+				taint.Received = taint.Received.Add(tainted)
+			}
+		}
+	}
+
+	for addr, currencies := range result.Addresses {
+		for currency, taint := range currencies {
+			taint.Held = wallets[addr][currency].taint
+		}
+	}
+	return result, nil
+}
+
+func checkModel(model string) error {
+	if model != MODEL_POISON && model != MODEL_HAIRCUT && model != MODEL_FIFO {
+		return fmt.Errorf("%w: %q", ErrUnknownModel, model)
+	}
+	return nil
+}
+
+func (r *Result) address(addr string, currency string) *AddressTaint {
+	if r.Addresses[addr] == nil {
+		r.Addresses[addr] = make(map[string]*AddressTaint)
+	}
+	if r.Addresses[addr][currency] == nil {
+		r.Addresses[addr][currency] = &AddressTaint{}
+	}
+	return r.Addresses[addr][currency]
+}
+
+// Analyze traces outputs of source from fromBlock with the query, adds the inputs of
+// every expanded address but the source, and propagates its taint. Without the inputs
+// the clean funds an address received would be missing and its outputs overtainted.
+// The graph is returned too with the inputs added, it may be truncated.
+func Analyze(ctx context.Context, query traverser.Query, model string, redis *redis.RedisClient) (*Result, *traverser.Graph, error) {
+	if err := checkModel(model); err != nil {
+		return nil, nil, err
+	}
+	if len(query.Roots) != 1 {
+		return nil, nil, fmt.Errorf("%w: taint needs exactly one source", traverser.ErrInvalidQuery)
+	}
+	query.Flow = traverser.FLOW_OUTPUT
+	graph, err := traverser.CollectBFS(ctx, query, redis)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := addInputs(ctx, query, graph, redis); err != nil {
+		return nil, nil, err
+	}
+	txs := make([]traverser.Tx, 0, len(*graph.Txs))
+	for _, tx := range *graph.Txs {
+		txs = append(txs, tx)
+	}
+	result, err := Propagate(query.Roots[0], query.FromBlock, txs, model)
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Info().Msgf("taint of %s (%s) reached %d addresses over %d txs", query.Roots[0], model, len(result.Addresses), len(result.Txs))
+	return result, graph, nil
+}
+
+// addInputs adds to the graph the txs received within the query block range by the
+// addresses the traversal expanded, the source excepted
+func addInputs(ctx context.Context, query traverser.Query, graph *traverser.Graph, redis *redis.RedisClient) error {
+	explorer, err := traverser.NewExplorer(query, redis)
+	if err != nil {
+		return err
+	}
+	expanded := []string{}
+	for hash, addr := range *graph.Addrs {
+		if addr.NeedTraverse && hash != query.Roots[0] {
+			expanded = append(expanded, hash)
+		}
+	}
+	sort.Strings(expanded)
+
+	for _, hash := range expanded {
+		if ctx.Err() != nil {
+			graph.Truncated = traverser.TruncatedBy(ctx)
+			return nil
+		}
+		txs, err := explorer.Transactions(ctx, hash, traverser.FLOW_INPUT)
+		if traverser.IsCancelled(err) {
+			graph.Truncated = traverser.TruncatedBy(ctx)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, tx := range txs {
+			if _, exists := (*graph.Txs)[tx.TxHash]; exists {
+				continue
+			}
+			if len(*graph.Txs) >= query.GraphSizeLimit {
+				graph.Truncated = traverser.TRUNCATED_SIZE_LIMIT
+				return nil
+			}
+			(*graph.Txs)[tx.TxHash] = tx
+		}
+	}
+	log.Info().Msgf("taint inputs of %d addresses added, %d txs, %s", len(expanded), len(*graph.Txs), explorer.Stats())
+	return nil
+}
@@ -0,0 +1,101 @@
+package taint
+
+import (
+	"chain-traverser/internal/traverser"
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func transfer(hash string, from string, to string, block int, eth int64) traverser.Tx {
+	return traverser.Tx{
+		From:           from,
+		To:             to,
+		TxHash:         hash,
+		Block:          block,
+		FlowByCurrency: map[string]decimal.Decimal{"ETH": decimal.NewFromInt(eth)},
+	}
+}
+
+// expected taint of an address in ETH
+type want struct {
+	received int64
+	held     int64
+}
+
+func TestPropagate(t *testing.T) {
+	// a holds 100 clean ETH when the source sends it 100 tainted ETH,
+	// then sends 50 to b and b passes them to c
+	mixed := []traverser.Tx{
+		transfer("0x4", "b", "c", 30, 50),
+		transfer("0x1", "clean", "a", 5, 100),
+		transfer("0x3", "a", "b", 20, 50),
+		transfer("0x2", "src", "a", 10, 100),
+	}
+	// a spends 150, more than its clean funds
+	overspend := []traverser.Tx{
+		transfer("0x1", "clean", "a", 5, 100),
+		transfer("0x2", "src", "a", 10, 100),
+		transfer("0x3", "a", "b", 20, 150),
+	}
+	// a receives the tainted funds first
+	taintedFirst := []traverser.Tx{
+		transfer("0x1", "src", "a", 5, 100),
+		transfer("0x2", "clean", "a", 10, 100),
+		transfer("0x3", "a", "b", 20, 50),
+	}
+	// a sends more than it received in the graph
+	outside := []traverser.Tx{
+		transfer("0x1", "src", "a", 10, 100),
+		transfer("0x2", "a", "b", 20, 400),
+	}
+
+	tests := []struct {
+		name      string
+		model     string
+		fromBlock int
+		txs       []traverser.Tx
+		want      map[string]want
+	}{
+		{"poison", MODEL_POISON, 0, mixed, map[string]want{"a": {100, 150}, "b": {50, 0}, "c": {50, 50}}},
+		{"haircut", MODEL_HAIRCUT, 0, mixed, map[string]want{"a": {100, 75}, "b": {25, 0}, "c": {25, 25}}},
+		{"fifo spends clean funds first", MODEL_FIFO, 0, mixed, map[string]want{"a": {100, 100}}},
+		{"fifo", MODEL_FIFO, 0, overspend, map[string]want{"a": {100, 50}, "b": {50, 50}}},
+		{"haircut overspend", MODEL_HAIRCUT, 0, overspend, map[string]want{"a": {100, 25}, "b": {75, 75}}},
+		{"fifo tainted first", MODEL_FIFO, 0, taintedFirst, map[string]want{"a": {100, 50}, "b": {50, 50}}},
+		{"haircut outside funds are clean", MODEL_HAIRCUT, 0, outside, map[string]want{"a": {100, 0}, "b": {100, 100}}},
+		{"poison outside funds are tainted", MODEL_POISON, 0, outside, map[string]want{"a": {100, 0}, "b": {400, 400}}},
+		{"txs before the block are ignored", MODEL_HAIRCUT, 10, mixed, map[string]want{"a": {100, 50}, "b": {50, 0}, "c": {50, 50}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Propagate("src", tt.fromBlock, tt.txs, tt.model)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Addresses) != len(tt.want) {
+				t.Errorf("tainted addresses %v, want %v", result.Addresses, tt.want)
+			}
+			for addr, w := range tt.want {
+				taint := result.Addresses[addr]["ETH"]
+				if taint == nil {
+					t.Errorf("%s not tainted", addr)
+					continue
+				}
+				if !taint.Received.Equal(decimal.NewFromInt(w.received)) {
+					t.Errorf("%s received %s, want %d", addr, taint.Received, w.received)
+				}
+				if !taint.Held.Equal(decimal.NewFromInt(w.held)) {
+					t.Errorf("%s holds %s, want %d", addr, taint.Held, w.held)
+				}
+			}
+		})
+	}
+}
+
+func TestPropagateUnknownModel(t *testing.T) {
+	if _, err := Propagate("src", 0, nil, "lifo"); !errors.Is(err, ErrUnknownModel) {
+		t.Errorf("got %v, want ErrUnknownModel", err)
+	}
+}
@@ -0,0 +1,64 @@
+package traverser
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+)
+
+// rough size of a parsed tx without its strings, used for cache limits
+const TX_OVERHEAD_BYTES = 256
+
+// ParseBlock decodes every transaction of a block blob,
+// lines are "from;txHash;to;value;usd;erc20|nil;erc20Value;erc20Usd"
+func ParseBlock(blockNumber string, blob string) []Tx {
+	number, _ := strconv.Atoi(blockNumber)
+	txs := []Tx{}
+	for i, tx := range strings.Split(blob, "\n") {
+		if tx == "" {
+			continue
+		}
+		vals := strings.Split(tx, ";")
+		if len(vals) < 8 {
+			log.Warn().Msgf("parseBlock skip corrupt tx in block %s: %s", blockNumber, tx)
+			continue
+		}
+
+		ethAmount, _ := decimal.NewFromString(vals[3])
+		ethAmount = ethAmount.Div(decimal.NewFromInt(1e18))
+		ethAmountUsdOnDay, _ := decimal.NewFromString(vals[4])
+		erc20 := vals[5]
+
+		totalUsdFlow := ethAmountUsdOnDay
+		flowByCurrency := make(map[string]decimal.Decimal)
+		flowByCurrency["ETH"] = ethAmount
+		if erc20 != "nil" {
+			erc20AmountUsdOnDay, _ := decimal.NewFromString(vals[7])
+			erc20Amount, _ := decimal.NewFromString(vals[6])
+			totalUsdFlow = ethAmountUsdOnDay.Add(erc20AmountUsdOnDay)
+			flowByCurrency[erc20] = erc20Amount
+		}
+
+		txs = append(txs, Tx{
+			From:           vals[0],
+			To:             vals[2],
+			TxHash:         vals[1],
+			Block:          number,
+			Index:          i,
+			TotalUsdFlow:   totalUsdFlow,
+			FlowByCurrency: flowByCurrency,
+		})
+	}
+	return txs
+}
+
+// blockSize estimates memory held by parsed txs of a block
+func blockSize(txs []Tx) int64 {
+	size := int64(TX_OVERHEAD_BYTES)
+	for _, tx := range txs {
+		size += int64(TX_OVERHEAD_BYTES + len(tx.From) + len(tx.To) + len(tx.TxHash) + 64*len(tx.FlowByCurrency))
+	}
+	return size
+}
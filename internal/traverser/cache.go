@@ -0,0 +1,110 @@
+package traverser
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+)
+
+type CacheStats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	Entries   int64
+	Bytes     int64
+}
+
+func (s CacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+func (s CacheStats) String() string {
+	return fmt.Sprintf("hits: %d, misses: %d, hit rate: %.2f, evictions: %d, entries: %d, bytes: %d",
+		s.Hits, s.Misses, s.HitRate(), s.Evictions, s.Entries, s.Bytes)
+}
+
+type cachedBlock struct {
+	key  string
+	txs  []Tx
+	size int64
+}
+
+// BlockCache is an LRU of parsed blocks bounded by their estimated size.
+// Cached txs are shared between readers and must not be modified.
+// A nil *BlockCache is a valid cache that holds nothing.
+type BlockCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	order    *list.List
+	items    map[string]*list.Element
+	stats    CacheStats
+}
+
+// NewBlockCache returns nil if maxBytes is not positive, so the cache is disabled
+func NewBlockCache(maxBytes int64) *BlockCache {
+	if maxBytes <= 0 {
+		return nil
+	}
+	return &BlockCache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (c *BlockCache) Get(key string) ([]Tx, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	c.stats.Hits++
+	c.order.MoveToFront(elem)
+	return elem.Value.(*cachedBlock).txs, true
+}
+
+// Add caches txs of a block, blocks larger than the whole cache are not kept
+func (c *BlockCache) Add(key string, txs []Tx) {
+	if c == nil {
+		return
+	}
+	size := blockSize(txs)
+	if size > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[key]; ok {
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.order.PushFront(&cachedBlock{key: key, txs: txs, size: size})
+	c.stats.Entries++
+	c.stats.Bytes += size
+	for c.stats.Bytes > c.maxBytes {
+		oldest := c.order.Back()
+		block := oldest.Value.(*cachedBlock)
+		c.order.Remove(oldest)
+		delete(c.items, block.key)
+		c.stats.Entries--
+		c.stats.Bytes -= block.size
+		c.stats.Evictions++
+	}
+}
+
+func (c *BlockCache) Stats() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
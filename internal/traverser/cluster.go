@@ -0,0 +1,191 @@
+package traverser
+
+import (
+	"chain-traverser/internal/storage/redis"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// leaves of an anchor with the same label kind
+	CLUSTER_BY_LABEL = "label"
+	// leaves of an anchor moving the same currencies
+	CLUSTER_BY_BEHAVIOUR = "behaviour"
+)
+
+const (
+	DEFAULT_CLUSTER_MIN_SIZE = 3
+	// cluster ids start with it, addresses never do
+	CLUSTER_ID_PREFIX = "cluster:"
+	// label kind of leaves without labels
+	KIND_UNLABELLED = "unlabelled"
+)
+
+// Cluster stands for leaves of the graph hanging off the same anchor address
+type Cluster struct {
+	Id     string
+	Anchor string
+	// FLOW_OUTPUT if the anchor sends to the members, FLOW_INPUT if it receives from them
+	Direction string
+	// label kind or currencies the members share
+	Key            string
+	Members        []string
+	Txs            int
+	FlowByCurrency map[string]decimal.Decimal
+	TotalUsdFlow   decimal.Decimal
+}
+
+func IsClusterId(id string) bool {
+	return strings.HasPrefix(id, CLUSTER_ID_PREFIX)
+}
+
+// leaf is an address of the graph with a single counterparty
+type leaf struct {
+	anchor     string
+	direction  string
+	currencies map[string]bool
+	txs        []string
+}
+
+// findLeaves returns addresses other than roots whose txs all go one way to a single counterparty
+func findLeaves(graph *Graph, query Query) map[string]*leaf {
+	leaves := make(map[string]*leaf)
+	mixed := make(map[string]bool)
+	visit := func(addr string, anchor string, direction string, tx Tx) {
+		if query.isRoot(addr) || mixed[addr] {
+			return
+		}
+		l, exists := leaves[addr]
+		if !exists {
+			l = &leaf{anchor: anchor, direction: direction, currencies: make(map[string]bool)}
+			leaves[addr] = l
+		}
+		if l.anchor != anchor || l.direction != direction {
+			mixed[addr] = true
+			delete(leaves, addr)
+			return
+		}
+		l.txs = append(l.txs, tx.TxHash)
+		for currency, amount := range tx.FlowByCurrency {
+			if amount.IsPositive() {
+				l.currencies[currency] = true
+			}
+		}
+	}
+	for _, tx := range *graph.Txs {
+		if tx.From == tx.To {
+			continue
+		}
+		// the anchor sends to the receiver, receives from the sender
+		visit(tx.To, tx.From, FLOW_OUTPUT, tx)
+		visit(tx.From, tx.To, FLOW_INPUT, tx)
+	}
+	return leaves
+}
+
+func clusterId(members []string) string {
+	hash := sha1.Sum([]byte(strings.Join(members, ",")))
+	return CLUSTER_ID_PREFIX + hex.EncodeToString(hash[:8])
+}
+
+// ClusterLeaves replaces groups of at least minSize leaves sharing an anchor and a
+// direction, and either a label kind or the currencies they move, by one node per
+// group. Txs of the members are rewired to the cluster id, the members are
+// listed in graph.Clusters and removed from graph.Addrs.
+func ClusterLeaves(ctx context.Context, graph *Graph, query Query, by string, minSize int, redis *redis.RedisClient) error {
+	if by != CLUSTER_BY_LABEL && by != CLUSTER_BY_BEHAVIOUR {
+		return fmt.Errorf("%w: unknown clustering %q", ErrInvalidQuery, by)
+	}
+	if minSize <= 0 {
+		minSize = DEFAULT_CLUSTER_MIN_SIZE
+	}
+	leaves := findLeaves(graph, query)
+
+	keys := make(map[string]string, len(leaves))
+	if by == CLUSTER_BY_LABEL {
+		hashes := make([]string, 0, len(leaves))
+		for addr := range leaves {
+			hashes = append(hashes, addr)
+		}
+		labels, err := newFetcher(redis, query.Fetch).labels(ctx, hashes)
+		if err != nil {
+			return err
+		}
+		for addr := range leaves {
+			keys[addr] = KIND_UNLABELLED
+			if kinds := labelKinds(labels[addr]); len(kinds) > 0 {
+				keys[addr] = kinds[0]
+			}
+		}
+	} else {
+		for addr, l := range leaves {
+			currencies := make([]string, 0, len(l.currencies))
+			for currency := range l.currencies {
+				currencies = append(currencies, currency)
+			}
+			sort.Strings(currencies)
+			keys[addr] = strings.Join(currencies, ",")
+		}
+	}
+
+	groups := make(map[string][]string)
+	for addr, l := range leaves {
+		group := l.anchor + "|" + l.direction + "|" + keys[addr]
+		groups[group] = append(groups[group], addr)
+	}
+
+	if graph.Clusters == nil {
+		graph.Clusters = make(map[string]*Cluster)
+	}
+	for _, members := range groups {
+		if len(members) < minSize {
+			continue
+		}
+		sort.Strings(members)
+		first := leaves[members[0]]
+		cluster := &Cluster{
+			Id:             clusterId(members),
+			Anchor:         first.anchor,
+			Direction:      first.direction,
+			Key:            keys[members[0]],
+			Members:        members,
+			FlowByCurrency: make(map[string]decimal.Decimal),
+		}
+		for _, member := range members {
+			for _, hash := range leaves[member].txs {
+				tx := (*graph.Txs)[hash]
+				if tx.From == member {
+					tx.From = cluster.Id
+				}
+				if tx.To == member {
+					tx.To = cluster.Id
+				}
+				(*graph.Txs)[hash] = tx
+				cluster.Txs++
+				cluster.TotalUsdFlow = cluster.TotalUsdFlow.Add(tx.TotalUsdFlow)
+				for currency, amount := range tx.FlowByCurrency {
+					cluster.FlowByCurrency[currency] = cluster.FlowByCurrency[currency].Add(amount)
+				}
+			}
+			delete(*graph.Addrs, member)
+		}
+		graph.Clusters[cluster.Id] = cluster
+	}
+	log.Info().Msgf("clustered %d leaves of %d into %d clusters by %s", clusteredCount(graph), len(leaves), len(graph.Clusters), by)
+	return nil
+}
+
+func clusteredCount(graph *Graph) int {
+	count := 0
+	for _, cluster := range graph.Clusters {
+		count += len(cluster.Members)
+	}
+	return count
+}
@@ -1,131 +1,77 @@
 package traverser
 
 import (
-	"chain-traverser/internal/config"
+	"chain-traverser/internal/storage"
 	"chain-traverser/internal/storage/redis"
-	"fmt"
-	"math/big"
-	"os"
+	"context"
+	"errors"
+	"sort"
 	"strconv"
-	"strings"
 
-	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"github.com/shopspring/decimal"
 )
 
-func addressBlocks(addr string, fromBlock int, toBlock int, redis *redis.RedisClient) (*[]int, error) {
-	bSlice, err := redis.GetAddressBlocks(&addr)
+func addressBlocks(ctx context.Context, addr string, fromBlock int, toBlock int, fetcher *fetcher) ([]string, error) {
+	lists, err := fetcher.addressBlocks(ctx, []string{addr})
 	if err != nil {
 		log.Err(err).Msgf("Cant get blocks for %s", addr)
 		return nil, err
 	}
-	var filteredBlocks []int
-	for _, block := range *bSlice {
+	var filteredBlocks []string
+	for _, block := range lists[addr] {
 		blockNumber, err := strconv.Atoi(block)
 		if err != nil {
-			log.Err(err).Msgf("Error converting block number to int: %d", blockNumber)
+			log.Err(err).Msgf("Error converting block number to int: %s", block)
 			continue
 		}
 		if blockNumber >= fromBlock && blockNumber <= toBlock {
-			filteredBlocks = append(filteredBlocks, blockNumber)
+			filteredBlocks = append(filteredBlocks, block)
 		}
 	}
 
-	return &filteredBlocks, nil
+	return filteredBlocks, nil
 }
 
-func blockTransactions(blockNumber string, addr string, flow string, redis *redis.RedisClient) (*[]Tx, error) {
-	bigInt := new(big.Int)
-	bigInt.SetString(blockNumber, 10)
-
-	block, err := redis.GetBlock(&blockNumber)
-	if err != nil {
-		log.Err(err).Msgf("blockTransactions can't get block: %s", blockNumber)
-		return nil, err
-	}
+// blockTransactions picks txs of a parsed block moving funds of addr in the query flow direction
+func blockTransactions(block []Tx, addr string, query Query) []Tx {
 	txs := []Tx{}
-	for _, tx := range strings.Split(*block, "\n") {
-		if tx == "" {
-			continue
-		}
-		vals := strings.Split(tx, ";")
-		from := vals[0]
-		to := vals[2]
-		txHash := vals[1]
-
-		if (flow == "input" || flow == "all") && to == addr ||
-			(flow == "output" || flow == "all") && from == addr ||
-			(flow == "all") && (from == addr || to == addr) {
-
-			ethAmount, _ := decimal.NewFromString(vals[3])
-			ethAmount = ethAmount.Div(decimal.NewFromInt(1e18))
-			ethAmountUsdOnDay, _ := decimal.NewFromString(vals[4])
-			erc20 := vals[5]
-
-			totalUsdFlow := ethAmountUsdOnDay
-			flowByCurrency := make(map[string]decimal.Decimal)
-			flowByCurrency["ETH"] = ethAmount
-			if erc20 != "nil" {
-				erc20AmountUsdOnDay, _ := decimal.NewFromString(vals[7])
-				erc20Amount, _ := decimal.NewFromString(vals[6])
-				totalUsdFlow = ethAmountUsdOnDay.Add(erc20AmountUsdOnDay)
-				flowByCurrency[erc20] = erc20Amount
-			}
-			// log.Debug().Msgf("tx %s %s %s", from, to, txHash)
-			txs = append(txs, Tx{
-				From:           from,
-				To:             to,
-				TxHash:         txHash,
-				TotalUsdFlow:   totalUsdFlow,
-				FlowByCurrency: flowByCurrency,
-			})
+	for _, tx := range block {
+		if query.followsInput() && tx.To == addr ||
+			query.followsOutput() && tx.From == addr {
+			txs = append(txs, tx)
 		}
-
 	}
-	return &txs, nil
+	return txs
 }
 
-func getAddress(addr AddrWithDepth, redis *redis.RedisClient) (*Addr, error) {
-	addrCnt, _ := redis.GetAddressTxNumber(&addr.hash)
-	needTraverse := true
-	if addr.depth != 0 && addrCnt > TRAVERSE_MAX_DEGREE {
-		log.Debug().Msgf("skip address cause of degree = ", addrCnt)
-		needTraverse = false
+func getAddress(ctx context.Context, addr AddrWithDepth, query Query, fetcher *fetcher) (*Addr, error) {
+	addrs, err := inspect(ctx, []string{addr.hash}, query, fetcher)
+	if err != nil {
+		return nil, err
 	}
-	return &Addr{Hash: addr.hash, Cnt: addrCnt, NeedTraverse: needTraverse}, nil
+	addrObj := addrs[addr.hash]
+	return &addrObj, nil
 }
 
-func getTrxFrom(addr string, fromBlock int, toBlock int, flow string, redis *redis.RedisClient) (*[]Tx, error) {
-	blocks, err := addressBlocks(addr, fromBlock, toBlock, redis)
+func getTrxFrom(ctx context.Context, addr string, query Query, fetcher *fetcher) (*[]Tx, error) {
+	blocks, err := addressBlocks(ctx, addr, query.FromBlock, query.ToBlock, fetcher)
 	if err != nil {
 		return nil, err
 	}
-	resultChan := make(chan []Tx, len(*blocks))
-	errChan := make(chan error, len(*blocks))
-
-	for _, block := range *blocks {
-		go func(block int) {
-			local_txs, err := blockTransactions(strconv.Itoa(block), addr, flow, redis)
-			if err != nil {
-				errChan <- err
-			} else {
-				resultChan <- *local_txs
-			}
-		}(block)
+	parsed, err := fetcher.blocks(ctx, blocks)
+	if err != nil {
+		log.Err(err).Msgf("getTrxFrom can't get blocks of %s", addr)
+		return nil, err
 	}
 
 	var txs []Tx
-
-	for range *blocks {
-		select {
-		case err := <-errChan:
-			log.Err(err).Msgf("getTransactionsByBlockNumber | Error: %s", err)
-		case result := <-resultChan:
-			txs = append(txs, result...)
-		}
+	for _, block := range blocks {
+		txs = append(txs, blockTransactions(parsed[block], addr, query)...)
+	}
+	txs, err = filterEdges(ctx, txs, func(hash string) bool { return hash == addr }, query, fetcher)
+	if err != nil {
+		return nil, err
 	}
-
 	return &txs, nil
 }
 
@@ -134,111 +80,135 @@ type AddrWithDepth struct {
 	depth int
 }
 
-type ParamsDFS struct {
-	Address        string
-	Depth          int
-	FromBlock      int
-	ToBlock        int
-	Flow           string
-	GraphSizeLimit int
+// CollectDFS walks the graph depth first from the query roots.
+//
+// Addresses closer than query.Depth to a root are expanded, each at the smallest
+// depth it is reached at: an address first met deep in one branch is expanded again
+// when another branch reaches it closer to the root. Neighbours are visited in address
+// order, so the same data always yields the same graph. The traversal stops once
+// query.GraphSizeLimit addresses or transactions are collected.
+func CollectDFS(ctx context.Context, query Query, redis *redis.RedisClient) (*Graph, error) {
+	return collectDFS(ctx, query, redis)
 }
 
-func (p ParamsDFS) String() string {
-	return fmt.Sprintf("address: %s, depth: %d, fromBlock: %d, toBlock: %d, flow: %s, graphSizeLimit: %d",
-		p.Address, p.Depth, p.FromBlock, p.ToBlock, p.Flow, p.GraphSizeLimit)
-}
-
-func CollectDFS(params ParamsDFS, redis *redis.RedisClient) (*Graph, error) {
-	log.Info().Msgf("CollectDFS: %s", fmt.Sprintf("%+v", params))
+func collectDFS(ctx context.Context, query Query, store store) (*Graph, error) {
+	query = query.withDefaults()
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+	log.Info().Msgf("CollectDFS: %s", query)
 	graph := &Graph{
 		Addrs: &map[string]Addr{},
 		Txs:   &map[string]Tx{},
 	}
-	firstAddr := AddrWithDepth{hash: params.Address, depth: 0}
-	stack := []AddrWithDepth{firstAddr}
+	fetcher := newFetcher(store, query.Fetch)
+	// smallest depth an address was expanded at
+	expanded := make(map[string]int)
+	// the first root is popped first
+	stack := []AddrWithDepth{}
+	for i := len(query.Roots) - 1; i >= 0; i-- {
+		stack = append(stack, AddrWithDepth{hash: query.Roots[i], depth: 0})
+	}
 
-	depthCnt := 0
-	// DFS logic
-	addressCnter := 0
-	for len(stack) > 0 && len(*graph.Addrs) < params.GraphSizeLimit && len(*graph.Txs) < params.GraphSizeLimit && depthCnt < params.Depth {
+	for len(stack) > 0 {
+		if ctx.Err() != nil {
+			graph.Truncated = TruncatedBy(ctx)
+			break
+		}
 		// Pop the top address from the stack
 		addr := stack[len(stack)-1]
 		stack = stack[:len(stack)-1]
 
-		if addr.depth >= params.Depth {
+		if addr.depth >= query.Depth {
 			continue
 		}
-		if _, exists := (*graph.Addrs)[addr.hash]; exists {
+		if depth, exists := expanded[addr.hash]; exists && depth <= addr.depth {
 			continue
 		}
 
-		addrObj, err := getAddress(addr, redis)
-		if err != nil {
-			log.Err(err).Msgf("Cant get address %s", addr.hash)
-			continue
+		addrObj, exists := (*graph.Addrs)[addr.hash]
+		if !exists {
+			if len(*graph.Addrs) >= query.GraphSizeLimit {
+				graph.Truncated = TRUNCATED_SIZE_LIMIT
+				break
+			}
+			fetched, err := getAddress(ctx, addr, query, fetcher)
+			if IsCancelled(err) {
+				graph.Truncated = TruncatedBy(ctx)
+				break
+			}
+			if errors.Is(err, storage.ErrUnavailable) {
+				return nil, err
+			}
+			if err != nil {
+				log.Err(err).Msgf("Cant get address %s", addr.hash)
+				continue
+			}
+			addrObj = *fetched
 		}
-		(*graph.Addrs)[addr.hash] = *addrObj
+		addrObj.Depth = addr.depth
+		(*graph.Addrs)[addr.hash] = addrObj
+		expanded[addr.hash] = addr.depth
 
 		if !addrObj.NeedTraverse {
 			continue
 		}
 
-		trxs, err := getTrxFrom(addr.hash, params.FromBlock, params.ToBlock, params.Flow, redis)
+		trxs, err := getTrxFrom(ctx, addr.hash, query, fetcher)
+		if IsCancelled(err) {
+			graph.Truncated = TruncatedBy(ctx)
+			break
+		}
+		if errors.Is(err, storage.ErrUnavailable) {
+			return nil, err
+		}
 		if err != nil {
 			log.Err(err).Msgf("Cant get transactions for %s", addr.hash)
 			continue
 		}
 
-		if addr.hash == params.Address {
-			addressCnter += 1
-		}
-
 		// Visit all transactions from this address
+		neighbours := make(map[string]bool)
 		for _, tx := range *trxs {
-			(*graph.Txs)[tx.TxHash] = tx
-			var addAddr string
+			if _, exists := (*graph.Txs)[tx.TxHash]; !exists {
+				if len(*graph.Txs) >= query.GraphSizeLimit {
+					graph.Truncated = TRUNCATED_SIZE_LIMIT
+					break
+				}
+				(*graph.Txs)[tx.TxHash] = tx
+			}
+			neighbour := tx.To
 			if tx.To == addr.hash {
-				addAddr = tx.From
-			} else {
-				addAddr = tx.To
+				neighbour = tx.From
+			}
+			// handle case when address sends to itself
+			if neighbour != addr.hash {
+				neighbours[neighbour] = true
 			}
-			if addAddr == addr.hash {
-				// handle case when address sends to itself
+		}
+		if graph.Truncated != "" {
+			break
+		}
+
+		// push in reverse order so the smallest address is popped first
+		sorted := make([]string, 0, len(neighbours))
+		for neighbour := range neighbours {
+			if depth, exists := expanded[neighbour]; exists && depth <= addr.depth+1 {
 				continue
 			}
-			stack = append(stack, AddrWithDepth{hash: addAddr, depth: addr.depth + 1})
+			sorted = append(sorted, neighbour)
+		}
+		sort.Sort(sort.Reverse(sort.StringSlice(sorted)))
+		for _, neighbour := range sorted {
+			stack = append(stack, AddrWithDepth{hash: neighbour, depth: addr.depth + 1})
 		}
 		if len(stack)%100 == 0 {
 			log.Info().Msgf("Stack length: %d", len(stack))
 		}
-		(*addrObj).NeedTraverse = false
-	}
-	if addressCnter > 2 {
-		log.Warn().Msgf("address cnter = %d", addressCnter)
 	}
+	markSeeds(graph, query)
+	graph.Stats = fetcher.Stats()
+	log.Info().Msgf("CollectDFS collected %d addresses, %d txs, truncated: %q, fetch stats: %s",
+		len(*graph.Addrs), len(*graph.Txs), graph.Truncated, graph.Stats)
 	return graph, nil
 }
-
-func main() {
-	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
-	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
-
-	cfg, err := config.NewConfig()
-
-	if err != nil {
-		log.Err(err).Msg("error reading config")
-		return
-	}
-	redis := redis.NewClient(&cfg.Redis)
-
-	// Example usage
-	params := ParamsDFS{"startAddress", 3, 0, 20_000_000, "all", 5000}
-	graph, err := CollectDFS(params, redis)
-	if err != nil {
-		fmt.Println("Error:", err)
-		return
-	}
-
-	// Print the collected graph
-	log.Debug().Msgf("Collected Graph: %s", graph)
-}
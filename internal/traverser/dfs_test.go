@@ -0,0 +1,266 @@
+package traverser
+
+import (
+	"chain-traverser/internal/storage"
+	"context"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+// edge is a transfer of 1 ETH worth 1 USD, alone in its block
+type edge struct {
+	from  string
+	to    string
+	block int
+}
+
+// memStore is an in-memory store holding what the indexer would write for the transfers
+type memStore struct {
+	blobs    map[string]string
+	blocks   map[string][]string
+	counters map[string]int64
+}
+
+func newMemStore(edges []edge) *memStore {
+	s := &memStore{blobs: map[string]string{}, blocks: map[string][]string{}, counters: map[string]int64{}}
+	sort.Slice(edges, func(i, j int) bool { return edges[i].block < edges[j].block })
+	for _, e := range edges {
+		s.transfer(e.from, e.to, e.block, decimal.NewFromInt(1))
+	}
+	return s
+}
+
+// transfer adds a 1 ETH transfer worth usd in its own block, blocks must be added in order.
+// Its hash is "from>to".
+func (s *memStore) transfer(from string, to string, block int, usd decimal.Decimal) {
+	number := strconv.Itoa(block)
+	s.blobs[number] = from + ";" + from + ">" + to + ";" + to + ";1000000000000000000;" + usd.String() + ";nil;0;0"
+	for _, addr := range []string{from, to} {
+		s.blocks[addr] = append(s.blocks[addr], number)
+		s.counters[addr]++
+	}
+}
+
+func (s *memStore) Version() string { return "1" }
+
+func (s *memStore) GetBlocks(ctx context.Context, blockNumbers []string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, number := range blockNumbers {
+		if blob, ok := s.blobs[number]; ok {
+			result[number] = blob
+		}
+	}
+	return result, nil
+}
+
+func (s *memStore) GetAddressTxNumbers(ctx context.Context, addrs []string) (map[string]int64, error) {
+	result := map[string]int64{}
+	for _, addr := range addrs {
+		if cnt, ok := s.counters[addr]; ok {
+			result[addr] = cnt
+		}
+	}
+	return result, nil
+}
+
+func (s *memStore) GetAddressesLabels(ctx context.Context, addrs []string) (map[string]*storage.Labels, error) {
+	return map[string]*storage.Labels{}, nil
+}
+
+func (s *memStore) GetAddressesBlocks(ctx context.Context, addrs []string) (map[string][]string, error) {
+	result := map[string][]string{}
+	for _, addr := range addrs {
+		result[addr] = s.blocks[addr]
+	}
+	return result, nil
+}
+
+// depths returns the depth of every address of the graph
+func depths(graph *Graph) map[string]int {
+	result := map[string]int{}
+	for hash, addr := range *graph.Addrs {
+		result[hash] = addr.Depth
+	}
+	return result
+}
+
+// txHashes returns the sorted tx hashes of the graph
+func txHashes(graph *Graph) []string {
+	result := []string{}
+	for hash := range *graph.Txs {
+		result = append(result, hash)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestCollectDFS(t *testing.T) {
+	tests := []struct {
+		name  string
+		edges []edge
+		query Query
+		// depth of every address in the graph
+		wantAddrs     map[string]int
+		wantTxs       []string
+		wantTruncated string
+	}{
+		{
+			name: "a shallower revisit expands again",
+			// b is first reached through a at depth 2, then from r at depth 1
+			edges:     []edge{{"r", "a", 1}, {"a", "b", 2}, {"r", "b", 3}, {"b", "c", 4}, {"c", "d", 5}},
+			query:     Query{Roots: []string{"r"}, Depth: 3, Flow: FLOW_OUTPUT},
+			wantAddrs: map[string]int{"r": 0, "a": 1, "b": 1, "c": 2},
+			wantTxs:   []string{"a>b", "b>c", "c>d", "r>a", "r>b"},
+		},
+		{
+			name:          "neighbours in address order",
+			edges:         []edge{{"r", "c", 1}, {"r", "a", 2}, {"r", "b", 3}},
+			query:         Query{Roots: []string{"r"}, Depth: 2, Flow: FLOW_OUTPUT, GraphSizeLimit: 3},
+			wantAddrs:     map[string]int{"r": 0, "a": 1, "b": 1},
+			wantTxs:       []string{"r>a", "r>b", "r>c"},
+			wantTruncated: TRUNCATED_SIZE_LIMIT,
+		},
+		{
+			name:      "depth",
+			edges:     []edge{{"r", "a", 1}, {"a", "b", 2}, {"b", "c", 3}},
+			query:     Query{Roots: []string{"r"}, Depth: 2, Flow: FLOW_OUTPUT},
+			wantAddrs: map[string]int{"r": 0, "a": 1},
+			wantTxs:   []string{"a>b", "r>a"},
+		},
+		{
+			name:      "zero depth",
+			edges:     []edge{{"r", "a", 1}},
+			query:     Query{Roots: []string{"r"}, Depth: 0},
+			wantAddrs: map[string]int{},
+			wantTxs:   []string{},
+		},
+		{
+			name:          "size limit on transactions",
+			edges:         []edge{{"r", "a", 1}, {"r", "b", 2}, {"r", "c", 3}, {"r", "d", 4}},
+			query:         Query{Roots: []string{"r"}, Depth: 2, Flow: FLOW_OUTPUT, GraphSizeLimit: 2},
+			wantAddrs:     map[string]int{"r": 0},
+			wantTxs:       []string{"r>a", "r>b"},
+			wantTruncated: TRUNCATED_SIZE_LIMIT,
+		},
+		{
+			name:      "inputs are followed back",
+			edges:     []edge{{"a", "r", 1}, {"b", "a", 2}, {"r", "c", 3}},
+			query:     Query{Roots: []string{"r"}, Depth: 2, Flow: FLOW_INPUT},
+			wantAddrs: map[string]int{"r": 0, "a": 1},
+			wantTxs:   []string{"a>r", "b>a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			graph, err := collectDFS(context.Background(), tt.query, newMemStore(tt.edges))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if addrs := depths(graph); !maps.Equal(addrs, tt.wantAddrs) {
+				t.Errorf("addresses %v, want %v", addrs, tt.wantAddrs)
+			}
+			if txs := txHashes(graph); !slices.Equal(txs, tt.wantTxs) {
+				t.Errorf("txs %v, want %v", txs, tt.wantTxs)
+			}
+			if graph.Truncated != tt.wantTruncated {
+				t.Errorf("truncated %q, want %q", graph.Truncated, tt.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestCollectDFSDeterministic(t *testing.T) {
+	edges := []edge{}
+	for i := 0; i < 20; i++ {
+		edges = append(edges, edge{"r", "n" + strconv.Itoa(i), i + 1}, edge{"n" + strconv.Itoa(i), "m" + strconv.Itoa(i), i + 100})
+	}
+	query := Query{Roots: []string{"r"}, Depth: 3, Flow: FLOW_OUTPUT, GraphSizeLimit: 15}
+	first, err := collectDFS(context.Background(), query, newMemStore(edges))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for run := 0; run < 10; run++ {
+		graph, err := collectDFS(context.Background(), query, newMemStore(edges))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !maps.Equal(depths(graph), depths(first)) || !slices.Equal(txHashes(graph), txHashes(first)) {
+			t.Fatalf("run %d collected %v, first run %v", run, depths(graph), depths(first))
+		}
+	}
+}
+
+func TestCollectBFS(t *testing.T) {
+	tests := []struct {
+		name          string
+		edges         []edge
+		query         Query
+		wantAddrs     map[string]int
+		wantTxs       []string
+		wantTruncated string
+	}{
+		{
+			name:      "every address at its smallest depth",
+			edges:     []edge{{"r", "a", 1}, {"a", "b", 2}, {"r", "b", 3}, {"b", "c", 4}, {"c", "d", 5}},
+			query:     Query{Roots: []string{"r"}, Depth: 3, Flow: FLOW_OUTPUT},
+			wantAddrs: map[string]int{"r": 0, "a": 1, "b": 1, "c": 2},
+			wantTxs:   []string{"a>b", "b>c", "c>d", "r>a", "r>b"},
+		},
+		{
+			name:      "several roots",
+			edges:     []edge{{"r", "a", 1}, {"s", "b", 2}, {"b", "c", 3}},
+			query:     Query{Roots: []string{"r", "s"}, Depth: 2, Flow: FLOW_OUTPUT},
+			wantAddrs: map[string]int{"r": 0, "s": 0, "a": 1, "b": 1},
+			wantTxs:   []string{"b>c", "r>a", "s>b"},
+		},
+		{
+			name:          "a level beyond the size limit is not expanded",
+			edges:         []edge{{"r", "a", 1}, {"r", "b", 2}, {"r", "c", 3}, {"a", "d", 4}},
+			query:         Query{Roots: []string{"r"}, Depth: 3, Flow: FLOW_OUTPUT, GraphSizeLimit: 3},
+			wantAddrs:     map[string]int{"r": 0},
+			wantTxs:       []string{"r>a", "r>b", "r>c"},
+			wantTruncated: TRUNCATED_SIZE_LIMIT,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			graph, err := collectBFS(context.Background(), tt.query, newMemStore(tt.edges))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if addrs := depths(graph); !maps.Equal(addrs, tt.wantAddrs) {
+				t.Errorf("addresses %v, want %v", addrs, tt.wantAddrs)
+			}
+			if txs := txHashes(graph); !slices.Equal(txs, tt.wantTxs) {
+				t.Errorf("txs %v, want %v", txs, tt.wantTxs)
+			}
+			if graph.Truncated != tt.wantTruncated {
+				t.Errorf("truncated %q, want %q", graph.Truncated, tt.wantTruncated)
+			}
+		})
+	}
+}
+
+// without limits both traversals collect the same graph
+func TestCollectDFSMatchesBFS(t *testing.T) {
+	edges := []edge{{"r", "a", 1}, {"a", "b", 2}, {"b", "r", 3}, {"b", "c", 4}, {"r", "c", 5}, {"c", "d", 6}, {"e", "a", 7}, {"d", "f", 8}}
+	for _, flow := range []string{FLOW_INPUT, FLOW_OUTPUT, FLOW_ALL} {
+		query := Query{Roots: []string{"r"}, Depth: 3, Flow: flow}
+		dfs, err := collectDFS(context.Background(), query, newMemStore(edges))
+		if err != nil {
+			t.Fatal(err)
+		}
+		bfs, err := collectBFS(context.Background(), query, newMemStore(edges))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !maps.Equal(depths(dfs), depths(bfs)) || !slices.Equal(txHashes(dfs), txHashes(bfs)) {
+			t.Errorf("flow %s: dfs %v %v, bfs %v %v", flow, depths(dfs), txHashes(dfs), depths(bfs), txHashes(bfs))
+		}
+	}
+}
@@ -1,11 +1,18 @@
 package traverser
 
-import "github.com/shopspring/decimal"
+import (
+	"context"
+	"errors"
+
+	"github.com/shopspring/decimal"
+)
 
 type Tx struct {
 	From           string
 	To             string
 	TxHash         string
+	Block          int
+	Index          int // position in the block, orders txs of the same block
 	FlowByCurrency map[string]decimal.Decimal
 	TotalUsdFlow   decimal.Decimal
 }
@@ -14,11 +21,42 @@ type Addr struct {
 	Hash         string
 	Cnt          int64
 	NeedTraverse bool
+	// SKIP_* reason the address was not expanded, empty if it was
+	Skipped string
+	// distance from the root the address was expanded at
+	Depth int
 }
 
 type Graph struct {
 	Addrs *map[string]Addr
 	Txs   *map[string]Tx
+	// why the traversal stopped before completion, empty if it didn't
+	Truncated string
+	Stats     FetchStats
+	// address -> root -> distance, for every address reachable from a root
+	Seeds map[string]map[string]int
+	// cluster id -> leaves it stands for, see ClusterLeaves
+	Clusters map[string]*Cluster
+}
+
+const (
+	TRUNCATED_TIMEOUT   = "timeout"
+	TRUNCATED_CANCELLED = "cancelled"
+	// the graph reached its size limit
+	TRUNCATED_SIZE_LIMIT = "size_limit"
+)
+
+// IsCancelled reports whether err is caused by the traversal context
+func IsCancelled(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// TruncatedBy returns the reason a traversal with the given context stops early
+func TruncatedBy(ctx context.Context) string {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return TRUNCATED_TIMEOUT
+	}
+	return TRUNCATED_CANCELLED
 }
 
 const GRAPH_LIMIT = 500_000
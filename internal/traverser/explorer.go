@@ -0,0 +1,46 @@
+package traverser
+
+import (
+	"chain-traverser/internal/storage/redis"
+	"context"
+)
+
+// Explorer reads the graph one address at a time with the limits and the fetch
+// scheduler of a query, for searches that drive the expansion themselves
+type Explorer struct {
+	query   Query
+	fetcher *fetcher
+}
+
+func NewExplorer(query Query, redis *redis.RedisClient) (*Explorer, error) {
+	query = query.withDefaults()
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+	return &Explorer{query: query, fetcher: newFetcher(redis, query.Fetch)}, nil
+}
+
+// Address returns the counter of hash, NeedTraverse is false if the query limits or label rules stop it
+func (e *Explorer) Address(ctx context.Context, hash string, depth int) (*Addr, error) {
+	addr, err := getAddress(ctx, AddrWithDepth{hash: hash, depth: depth}, e.query, e.fetcher)
+	if err != nil {
+		return nil, err
+	}
+	addr.Depth = depth
+	return addr, nil
+}
+
+// Transactions returns txs of hash within the query block range in the given flow direction
+func (e *Explorer) Transactions(ctx context.Context, hash string, flow string) ([]Tx, error) {
+	query := e.query
+	query.Flow = flow
+	txs, err := getTrxFrom(ctx, hash, query, e.fetcher)
+	if err != nil {
+		return nil, err
+	}
+	return *txs, nil
+}
+
+func (e *Explorer) Stats() FetchStats {
+	return e.fetcher.Stats()
+}
@@ -0,0 +1,290 @@
+package traverser
+
+import (
+	"chain-traverser/internal/config"
+	"chain-traverser/internal/storage"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_FETCH_WORKERS    = 8
+	DEFAULT_FETCH_BATCH_SIZE = 100
+	DEFAULT_CACHE_BYTES      = 256 << 20
+)
+
+// FetchOptions bound the load a single traversal puts on Redis
+type FetchOptions struct {
+	Workers   int // concurrent round trips
+	BatchSize int // keys per round trip
+	// memory limit of parsed blocks kept during the traversal
+	CacheBytes int64
+	// process-wide cache of parsed blocks, nil if disabled
+	Shared *BlockCache
+}
+
+func NewFetchOptions(cfg *config.TraverserConfig) FetchOptions {
+	return FetchOptions{
+		Workers:    cfg.FetchWorkers,
+		BatchSize:  cfg.FetchBatchSize,
+		CacheBytes: int64(cfg.CacheMB) << 20,
+	}
+}
+
+func (o FetchOptions) withDefaults() FetchOptions {
+	if o.Workers <= 0 {
+		o.Workers = DEFAULT_FETCH_WORKERS
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = DEFAULT_FETCH_BATCH_SIZE
+	}
+	if o.CacheBytes <= 0 {
+		o.CacheBytes = DEFAULT_CACHE_BYTES
+	}
+	return o
+}
+
+type FetchStats struct {
+	Requested  int64 // blocks asked for
+	Deduped    int64 // blocks served from an earlier fetch of the same traversal
+	SharedHits int64 // blocks served from the process-wide cache
+	Fetched    int64 // blocks read from Redis
+	Missing    int64 // blocks that are not indexed
+	Batches    int64 // Redis round trips, counters and block lists included
+	Elapsed    time.Duration
+	Cache      CacheStats // traversal cache
+}
+
+// HitRate is the share of requested blocks that weren't read from Redis
+func (s FetchStats) HitRate() float64 {
+	if s.Requested == 0 {
+		return 0
+	}
+	return float64(s.Deduped+s.SharedHits) / float64(s.Requested)
+}
+
+func (s FetchStats) String() string {
+	return fmt.Sprintf("requested: %d, deduped: %d, shared hits: %d, hit rate: %.2f, fetched: %d, missing: %d, batches: %d, elapsed: %s, cache: {%s}",
+		s.Requested, s.Deduped, s.SharedHits, s.HitRate(), s.Fetched, s.Missing, s.Batches, s.Elapsed, s.Cache)
+}
+
+// store holds the indexed data a traversal reads, *redis.RedisClient outside of tests
+type store interface {
+	Version() string
+	GetBlocks(ctx context.Context, blockNumbers []string) (map[string]string, error)
+	GetAddressTxNumbers(ctx context.Context, addrs []string) (map[string]int64, error)
+	GetAddressesLabels(ctx context.Context, addrs []string) (map[string]*storage.Labels, error)
+	GetAddressesBlocks(ctx context.Context, addrs []string) (map[string][]string, error)
+}
+
+// fetcher schedules the Redis reads of one traversal: keys are read in batches
+// by at most Workers concurrent round trips, and blocks are parsed once and
+// kept in the traversal cache as long as it fits in CacheBytes.
+type fetcher struct {
+	store store
+	opts  FetchOptions
+	sem   chan struct{}
+	cache *BlockCache
+
+	mu    sync.Mutex
+	stats FetchStats
+}
+
+func newFetcher(store store, opts FetchOptions) *fetcher {
+	opts = opts.withDefaults()
+	return &fetcher{
+		store: store,
+		opts:  opts,
+		sem:   make(chan struct{}, opts.Workers),
+		cache: NewBlockCache(opts.CacheBytes),
+	}
+}
+
+func (f *fetcher) Stats() FetchStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stats := f.stats
+	stats.Cache = f.cache.Stats()
+	return stats
+}
+
+// run splits keys into batches and calls fn for each of them on the worker pool.
+// It stops scheduling on the first error and returns it once running batches are done.
+func (f *fetcher) run(ctx context.Context, keys []string, fn func(batch []string) error) error {
+	start := time.Now()
+	defer func() {
+		f.mu.Lock()
+		f.stats.Elapsed += time.Since(start)
+		f.mu.Unlock()
+	}()
+
+	var wg sync.WaitGroup
+	var errMu sync.Mutex
+	var fetchErr error
+	failed := func() bool {
+		errMu.Lock()
+		defer errMu.Unlock()
+		return fetchErr != nil
+	}
+
+	for i := 0; i < len(keys) && !failed(); i += f.opts.BatchSize {
+		batch := keys[i:min(i+f.opts.BatchSize, len(keys))]
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return ctx.Err()
+		case f.sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-f.sem }()
+			err := fn(batch)
+			f.mu.Lock()
+			f.stats.Batches++
+			f.mu.Unlock()
+			if err != nil {
+				errMu.Lock()
+				if fetchErr == nil {
+					fetchErr = err
+				}
+				errMu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return fetchErr
+}
+
+// blocks returns parsed txs of blockNumbers, blocks that are not indexed are left out
+func (f *fetcher) blocks(ctx context.Context, blockNumbers []string) (map[string][]Tx, error) {
+	// shared entries belong to a keyspace version, the migrator may switch it
+	version := f.store.Version()
+	result := make(map[string][]Tx, len(blockNumbers))
+	missing := []string{}
+	queued := make(map[string]bool)
+	for _, number := range blockNumbers {
+		if queued[number] {
+			continue
+		}
+		queued[number] = true
+		if txs, ok := f.cache.Get(number); ok {
+			f.count(func(s *FetchStats) { s.Deduped++ })
+			// not indexed blocks are cached as nil to skip them next time
+			if txs != nil {
+				result[number] = txs
+			}
+			continue
+		}
+		if txs, ok := f.opts.Shared.Get(version + ":" + number); ok {
+			f.count(func(s *FetchStats) { s.SharedHits++ })
+			f.cache.Add(number, txs)
+			result[number] = txs
+			continue
+		}
+		missing = append(missing, number)
+	}
+	f.count(func(s *FetchStats) { s.Requested += int64(len(blockNumbers)) })
+
+	var resultMu sync.Mutex
+	err := f.run(ctx, missing, func(batch []string) error {
+		blobs, err := f.store.GetBlocks(ctx, batch)
+		if err != nil {
+			return err
+		}
+		for _, number := range batch {
+			blob, ok := blobs[number]
+			if !ok {
+				f.cache.Add(number, nil)
+				f.count(func(s *FetchStats) { s.Missing++ })
+				continue
+			}
+			txs := ParseBlock(number, blob)
+			f.cache.Add(number, txs)
+			f.opts.Shared.Add(version+":"+number, txs)
+			f.count(func(s *FetchStats) { s.Fetched++ })
+			resultMu.Lock()
+			result[number] = txs
+			resultMu.Unlock()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (f *fetcher) count(fn func(*FetchStats)) {
+	f.mu.Lock()
+	fn(&f.stats)
+	f.mu.Unlock()
+}
+
+// counters returns transaction counters of addrs, unknown addresses are left out
+func (f *fetcher) counters(ctx context.Context, addrs []string) (map[string]int64, error) {
+	var mu sync.Mutex
+	result := make(map[string]int64, len(addrs))
+	err := f.run(ctx, addrs, func(batch []string) error {
+		counters, err := f.store.GetAddressTxNumbers(ctx, batch)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for addr, cnt := range counters {
+			result[addr] = cnt
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// labels returns labels of addrs, unlabelled addresses are left out
+func (f *fetcher) labels(ctx context.Context, addrs []string) (map[string]*storage.Labels, error) {
+	var mu sync.Mutex
+	result := make(map[string]*storage.Labels, len(addrs))
+	err := f.run(ctx, addrs, func(batch []string) error {
+		labels, err := f.store.GetAddressesLabels(ctx, batch)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for addr, l := range labels {
+			result[addr] = l
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// addressBlocks returns block lists of addrs
+func (f *fetcher) addressBlocks(ctx context.Context, addrs []string) (map[string][]string, error) {
+	var mu sync.Mutex
+	result := make(map[string][]string, len(addrs))
+	err := f.run(ctx, addrs, func(batch []string) error {
+		blocks, err := f.store.GetAddressesBlocks(ctx, batch)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for addr, list := range blocks {
+			result[addr] = list
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
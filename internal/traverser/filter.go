@@ -0,0 +1,112 @@
+package traverser
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// the currency every tx carries, amounts of other currencies are ERC20 transfers
+const NATIVE_CURRENCY = "ETH"
+
+// EdgeFilter restricts the transactions a traversal follows. Filtered out txs are
+// neither returned nor expanded. Zero values leave an option unset.
+type EdgeFilter struct {
+	// txs moving one of these currencies, matched case-insensitively
+	Currencies []string
+	MinUsd     decimal.Decimal
+	MaxUsd     decimal.Decimal
+	// native amount in ETH
+	MinAmount decimal.Decimal
+	MaxAmount decimal.Decimal
+	// txs whose counterparty has one of these label kinds, see labelKinds
+	CounterpartyTypes []string
+}
+
+func (f EdgeFilter) Validate() error {
+	if f.MinUsd.IsNegative() || f.MaxUsd.IsNegative() || f.MinAmount.IsNegative() || f.MaxAmount.IsNegative() {
+		return fmt.Errorf("%w: negative edge filter", ErrInvalidQuery)
+	}
+	if f.MaxUsd.IsPositive() && f.MinUsd.GreaterThan(f.MaxUsd) {
+		return fmt.Errorf("%w: edge usd range %s..%s", ErrInvalidQuery, f.MinUsd, f.MaxUsd)
+	}
+	if f.MaxAmount.IsPositive() && f.MinAmount.GreaterThan(f.MaxAmount) {
+		return fmt.Errorf("%w: edge amount range %s..%s", ErrInvalidQuery, f.MinAmount, f.MaxAmount)
+	}
+	return nil
+}
+
+func (f EdgeFilter) String() string {
+	return fmt.Sprintf("currencies: %v, usd: %s..%s, amount: %s..%s, counterparties: %v",
+		f.Currencies, f.MinUsd, f.MaxUsd, f.MinAmount, f.MaxAmount, f.CounterpartyTypes)
+}
+
+// matches checks every option but the counterparty labels
+func (f EdgeFilter) matches(tx Tx) bool {
+	if len(f.Currencies) > 0 {
+		moved := false
+		for currency, amount := range tx.FlowByCurrency {
+			if amount.IsPositive() && matchesAny([]string{strings.ToLower(currency)}, f.Currencies) {
+				moved = true
+				break
+			}
+		}
+		if !moved {
+			return false
+		}
+	}
+	if tx.TotalUsdFlow.LessThan(f.MinUsd) || f.MaxUsd.IsPositive() && tx.TotalUsdFlow.GreaterThan(f.MaxUsd) {
+		return false
+	}
+	native := tx.FlowByCurrency[NATIVE_CURRENCY]
+	if native.LessThan(f.MinAmount) || f.MaxAmount.IsPositive() && native.GreaterThan(f.MaxAmount) {
+		return false
+	}
+	return true
+}
+
+// filterEdges keeps txs passing the query edge filter. The counterparty of a tx is
+// its end that is not expanded, txs between two expanded addresses are kept.
+func filterEdges(ctx context.Context, txs []Tx, expanded func(hash string) bool, query Query, fetcher *fetcher) ([]Tx, error) {
+	filter := query.Edges
+	kept := []Tx{}
+	counterparties := make(map[string]bool)
+	for _, tx := range txs {
+		if !filter.matches(tx) {
+			continue
+		}
+		kept = append(kept, tx)
+		for _, hash := range []string{tx.From, tx.To} {
+			if !expanded(hash) {
+				counterparties[hash] = true
+			}
+		}
+	}
+	if len(filter.CounterpartyTypes) == 0 || len(kept) == 0 {
+		return kept, nil
+	}
+
+	hashes := make([]string, 0, len(counterparties))
+	for hash := range counterparties {
+		hashes = append(hashes, hash)
+	}
+	labels, err := fetcher.labels(ctx, hashes)
+	if err != nil {
+		return nil, err
+	}
+	typed := []Tx{}
+	for _, tx := range kept {
+		counterparty := ""
+		if !expanded(tx.From) {
+			counterparty = tx.From
+		} else if !expanded(tx.To) {
+			counterparty = tx.To
+		}
+		if counterparty == "" || matchesAny(labelKinds(labels[counterparty]), filter.CounterpartyTypes) {
+			typed = append(typed, tx)
+		}
+	}
+	return typed, nil
+}
@@ -0,0 +1,103 @@
+package traverser
+
+import (
+	"chain-traverser/internal/storage"
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// why an address of the graph was not expanded
+const (
+	// more transactions than query.MaxDegree
+	SKIP_MAX_DEGREE = "max_degree"
+	// labelled with one of query.NeverExpand
+	SKIP_LABEL = "label"
+	// in query.Visited
+	SKIP_VISITED = "visited"
+)
+
+// label kinds of services moving funds of many unrelated users, following them
+// only adds noise, and of addresses an investigation wants to see through
+var (
+	DEFAULT_NEVER_EXPAND  = []string{"exchange", "dex", "bridge"}
+	DEFAULT_ALWAYS_EXPAND = []string{"suspect"}
+)
+
+// labelKinds returns the lowercased type and secondary labels of an address
+func labelKinds(labels *storage.Labels) []string {
+	if labels == nil {
+		return nil
+	}
+	kinds := []string{}
+	if labels.Type != "" {
+		kinds = append(kinds, strings.ToLower(labels.Type))
+	}
+	if labels.Seconary != nil {
+		for _, label := range *labels.Seconary {
+			kinds = append(kinds, strings.ToLower(label))
+		}
+	}
+	return kinds
+}
+
+func matchesAny(kinds []string, rules []string) bool {
+	for _, rule := range rules {
+		if slices.Contains(kinds, strings.ToLower(rule)) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipReason returns why the address should not be expanded, empty if it should.
+// Roots are always expanded and visited addresses never, then label rules win over the degree limit.
+func (q Query) skipReason(hash string, cnt int64, labels *storage.Labels) string {
+	if q.isRoot(hash) {
+		return ""
+	}
+	if q.Visited[hash] {
+		return SKIP_VISITED
+	}
+	kinds := labelKinds(labels)
+	if matchesAny(kinds, q.AlwaysExpand) {
+		return ""
+	}
+	if matchesAny(kinds, q.NeverExpand) {
+		return SKIP_LABEL
+	}
+	if cnt > int64(q.MaxDegree) {
+		return SKIP_MAX_DEGREE
+	}
+	return ""
+}
+
+// inspect reads counters and, when the query has label rules, labels of hashes
+// and decides which of them are expanded
+func inspect(ctx context.Context, hashes []string, query Query, fetcher *fetcher) (map[string]Addr, error) {
+	counters, err := fetcher.counters(ctx, hashes)
+	if err != nil {
+		return nil, err
+	}
+	labels := map[string]*storage.Labels{}
+	if len(query.NeverExpand) > 0 || len(query.AlwaysExpand) > 0 {
+		labels, err = fetcher.labels(ctx, hashes)
+		if err != nil {
+			return nil, err
+		}
+	}
+	addrs := make(map[string]Addr, len(hashes))
+	for _, hash := range hashes {
+		// addresses the indexer never saw have no counter
+		addr := Addr{Hash: hash, Cnt: counters[hash], NeedTraverse: true}
+		addr.Skipped = query.skipReason(hash, addr.Cnt, labels[hash])
+		if addr.Skipped != "" {
+			log.Debug().Msgf("skip %s: %s, degree = %d", hash, addr.Skipped, addr.Cnt)
+			addr.NeedTraverse = false
+		}
+		addrs[hash] = addr
+	}
+	return addrs, nil
+}
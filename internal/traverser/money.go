@@ -0,0 +1,197 @@
+package traverser
+
+import (
+	"chain-traverser/internal/storage"
+	"chain-traverser/internal/storage/redis"
+	"container/heap"
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+)
+
+// moneyItem is an address waiting to be expanded with the USD amount that reached it
+type moneyItem struct {
+	hash   string
+	depth  int
+	amount decimal.Decimal
+}
+
+// moneyQueue is a max-heap by amount, ties are broken by address to keep the order stable
+type moneyQueue []moneyItem
+
+func (q moneyQueue) Len() int { return len(q) }
+func (q moneyQueue) Less(i, j int) bool {
+	if !q[i].amount.Equal(q[j].amount) {
+		return q[i].amount.GreaterThan(q[j].amount)
+	}
+	return q[i].hash < q[j].hash
+}
+func (q moneyQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *moneyQueue) Push(x any)   { *q = append(*q, x.(moneyItem)) }
+func (q *moneyQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+type Money struct {
+	redis *redis.RedisClient
+}
+
+func (t *Money) Collect(ctx context.Context, query Query) (*Graph, error) {
+	return CollectMoney(ctx, query, t.redis)
+}
+
+// CollectMoney follows the money from the query roots best first: the address that
+// received the largest traced USD amount is expanded next. The amount traced to an
+// address is what it received, capped by the amount traced to the sender. Transactions below
+// query.MinEdgeUsd are neither followed nor returned. The initial amount is what the
+// roots moved, the traversal ends when the best address left received less than
+// query.MinTracedFraction of it.
+//
+// Money is traced along outputs, or back along inputs with FLOW_INPUT. With FLOW_ALL
+// inputs of expanded addresses are returned but not traced.
+func CollectMoney(ctx context.Context, query Query, redis *redis.RedisClient) (*Graph, error) {
+	return collectMoney(ctx, query, redis)
+}
+
+func collectMoney(ctx context.Context, query Query, store store) (*Graph, error) {
+	query = query.withDefaults()
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+	log.Info().Msgf("CollectMoney: %s, minEdgeUsd: %s, minTracedFraction: %.4f", query, query.MinEdgeUsd, query.MinTracedFraction)
+	graph := &Graph{
+		Addrs: &map[string]Addr{},
+		Txs:   &map[string]Tx{},
+	}
+	fetcher := newFetcher(store, query.Fetch)
+
+	queue := &moneyQueue{}
+	// roots go first whatever they received, the initial amount is known once they are expanded
+	rootsLeft := len(query.Roots)
+	initial := decimal.Zero
+	var threshold *decimal.Decimal
+
+	for rootsLeft > 0 || queue.Len() > 0 {
+		if ctx.Err() != nil {
+			graph.Truncated = TruncatedBy(ctx)
+			break
+		}
+		var item moneyItem
+		if rootsLeft > 0 {
+			item = moneyItem{hash: query.Roots[len(query.Roots)-rootsLeft]}
+			rootsLeft--
+		} else {
+			if threshold == nil {
+				t := initial.Mul(decimal.NewFromFloat(query.MinTracedFraction))
+				threshold = &t
+				log.Info().Msgf("CollectMoney initial amount %s USD, threshold %s USD", initial, threshold)
+			}
+			item = heap.Pop(queue).(moneyItem)
+			if item.amount.LessThan(*threshold) {
+				log.Info().Msgf("CollectMoney stops at %s USD, below %s USD", item.amount, threshold)
+				break
+			}
+		}
+		if item.depth >= query.Depth {
+			continue
+		}
+		if _, exists := (*graph.Addrs)[item.hash]; exists {
+			continue
+		}
+		if len(*graph.Addrs) >= query.GraphSizeLimit {
+			graph.Truncated = TRUNCATED_SIZE_LIMIT
+			break
+		}
+
+		addrObj, err := getAddress(ctx, AddrWithDepth{hash: item.hash, depth: item.depth}, query, fetcher)
+		if IsCancelled(err) {
+			graph.Truncated = TruncatedBy(ctx)
+			break
+		}
+		if errors.Is(err, storage.ErrUnavailable) {
+			return nil, err
+		}
+		if err != nil {
+			log.Err(err).Msgf("Cant get address %s", item.hash)
+			continue
+		}
+		addrObj.Depth = item.depth
+		(*graph.Addrs)[item.hash] = *addrObj
+		if !addrObj.NeedTraverse {
+			continue
+		}
+
+		trxs, err := getTrxFrom(ctx, item.hash, query, fetcher)
+		if IsCancelled(err) {
+			graph.Truncated = TruncatedBy(ctx)
+			break
+		}
+		if errors.Is(err, storage.ErrUnavailable) {
+			return nil, err
+		}
+		if err != nil {
+			log.Err(err).Msgf("Cant get transactions for %s", item.hash)
+			continue
+		}
+
+		// amount traced to every counterparty
+		backward := query.Flow == FLOW_INPUT
+		received := make(map[string]decimal.Decimal)
+		for _, tx := range *trxs {
+			if tx.TotalUsdFlow.LessThan(query.MinEdgeUsd) {
+				continue
+			}
+			if _, exists := (*graph.Txs)[tx.TxHash]; !exists {
+				if len(*graph.Txs) >= query.GraphSizeLimit {
+					graph.Truncated = TRUNCATED_SIZE_LIMIT
+					break
+				}
+				(*graph.Txs)[tx.TxHash] = tx
+			}
+			sent := tx.From == item.hash
+			neighbour := tx.To
+			if !sent {
+				neighbour = tx.From
+			}
+			if sent != backward && neighbour != item.hash {
+				received[neighbour] = received[neighbour].Add(tx.TotalUsdFlow)
+			}
+		}
+		if graph.Truncated != "" {
+			break
+		}
+
+		neighbours := make([]string, 0, len(received))
+		for neighbour := range received {
+			if _, exists := (*graph.Addrs)[neighbour]; !exists {
+				neighbours = append(neighbours, neighbour)
+			}
+		}
+		sort.Strings(neighbours)
+		for _, neighbour := range neighbours {
+			amount := received[neighbour]
+			if !query.isRoot(item.hash) {
+				// an address passes on at most what was traced to it
+				amount = decimal.Min(amount, item.amount)
+			}
+			heap.Push(queue, moneyItem{hash: neighbour, depth: item.depth + 1, amount: amount})
+		}
+
+		if query.isRoot(item.hash) {
+			for _, amount := range received {
+				initial = initial.Add(amount)
+			}
+		}
+	}
+	markSeeds(graph, query)
+	graph.Stats = fetcher.Stats()
+	log.Info().Msgf("CollectMoney collected %d addresses, %d txs, truncated: %q, fetch stats: %s",
+		len(*graph.Addrs), len(*graph.Txs), graph.Truncated, graph.Stats)
+	return graph, nil
+}
@@ -0,0 +1,91 @@
+package traverser
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+// usdEdge is a transfer of usd dollars, alone in its block
+type usdEdge struct {
+	from  string
+	to    string
+	block int
+	usd   int64
+}
+
+func newUsdStore(edges []usdEdge) *memStore {
+	s := &memStore{blobs: map[string]string{}, blocks: map[string][]string{}, counters: map[string]int64{}}
+	for _, e := range edges {
+		s.transfer(e.from, e.to, e.block, decimal.NewFromInt(e.usd))
+	}
+	return s
+}
+
+func TestCollectMoney(t *testing.T) {
+	tests := []struct {
+		name          string
+		edges         []usdEdge
+		query         Query
+		wantAddrs     map[string]int
+		wantTxs       []string
+		wantTruncated string
+	}{
+		{
+			name:          "largest amount first",
+			edges:         []usdEdge{{"r", "a", 1, 50}, {"r", "b", 2, 60}, {"a", "c", 3, 1000}, {"b", "d", 4, 55}},
+			query:         Query{Roots: []string{"r"}, Depth: 5, Flow: FLOW_OUTPUT, GraphSizeLimit: 4},
+			wantAddrs:     map[string]int{"r": 0, "b": 1, "d": 2, "a": 1},
+			wantTxs:       []string{"a>c", "b>d", "r>a", "r>b"},
+			wantTruncated: TRUNCATED_SIZE_LIMIT,
+		},
+		{
+			name:      "stops below the traced fraction",
+			edges:     []usdEdge{{"r", "a", 1, 90}, {"r", "b", 2, 10}, {"a", "c", 3, 5}},
+			query:     Query{Roots: []string{"r"}, Depth: 5, Flow: FLOW_OUTPUT, MinTracedFraction: 0.2},
+			wantAddrs: map[string]int{"r": 0, "a": 1},
+			wantTxs:   []string{"a>c", "r>a", "r>b"},
+		},
+		{
+			name:      "inputs are returned but not traced with flow all",
+			edges:     []usdEdge{{"r", "a", 1, 100}, {"b", "r", 2, 1000}, {"a", "c", 3, 10}},
+			query:     Query{Roots: []string{"r"}, Depth: 5, Flow: FLOW_ALL, MinTracedFraction: 0.5},
+			wantAddrs: map[string]int{"r": 0, "a": 1},
+			wantTxs:   []string{"a>c", "b>r", "r>a"},
+		},
+		{
+			name:      "inputs are traced back",
+			edges:     []usdEdge{{"c", "b", 1, 50}, {"b", "r", 2, 100}, {"r", "a", 3, 1000}},
+			query:     Query{Roots: []string{"r"}, Depth: 3, Flow: FLOW_INPUT},
+			wantAddrs: map[string]int{"r": 0, "b": 1, "c": 2},
+			wantTxs:   []string{"b>r", "c>b"},
+		},
+		{
+			name:      "small transactions are left out",
+			edges:     []usdEdge{{"r", "a", 1, 5}, {"r", "b", 2, 50}},
+			query:     Query{Roots: []string{"r"}, Depth: 3, Flow: FLOW_OUTPUT, MinEdgeUsd: decimal.NewFromInt(10)},
+			wantAddrs: map[string]int{"r": 0, "b": 1},
+			wantTxs:   []string{"r>b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			graph, err := collectMoney(context.Background(), tt.query, newUsdStore(tt.edges))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if addrs := depths(graph); !maps.Equal(addrs, tt.wantAddrs) {
+				t.Errorf("addresses %v, want %v", addrs, tt.wantAddrs)
+			}
+			if txs := txHashes(graph); !slices.Equal(txs, tt.wantTxs) {
+				t.Errorf("txs %v, want %v", txs, tt.wantTxs)
+			}
+			if graph.Truncated != tt.wantTruncated {
+				t.Errorf("truncated %q, want %q", graph.Truncated, tt.wantTruncated)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package traverser
+
+import (
+	"chain-traverser/internal/storage/redis"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	FLOW_INPUT  = "input"
+	FLOW_OUTPUT = "output"
+	FLOW_ALL    = "all"
+)
+
+const (
+	ALGO_DFS = "dfs"
+	ALGO_BFS = "bfs"
+	// best first by USD amount, see CollectMoney
+	ALGO_MONEY = "money"
+)
+
+// the last block a query reaches when ToBlock is not set
+const MAX_BLOCK = 99999999
+
+var ErrInvalidQuery = errors.New("invalid query")
+
+// Query describes a traversal, every algorithm supports all of its options
+type Query struct {
+	Roots     []string
+	Depth     int
+	Flow      string // FLOW_*, direction of transactions followed from an address
+	FromBlock int
+	ToBlock   int
+	// max number of addresses and of transactions in the graph
+	GraphSizeLimit int
+	// addresses with more transactions are not expanded, roots excepted
+	MaxDegree int
+	// label kinds, matched against the type and the secondary labels of an address:
+	// addresses with one of NeverExpand are not expanded, those with one of AlwaysExpand
+	// are expanded whatever their degree. DEFAULT_* if nil
+	NeverExpand  []string
+	AlwaysExpand []string
+	// transactions followed
+	Edges EdgeFilter
+	// addresses expanded by an earlier request, they are not expanded again
+	Visited map[string]bool
+	// money traversal only: smallest USD amount of a followed transaction
+	MinEdgeUsd decimal.Decimal
+	// money traversal only: stop when the best address left received less than
+	// this fraction of the amount moved by the roots
+	MinTracedFraction float64
+	Fetch             FetchOptions
+}
+
+func (q Query) String() string {
+	return fmt.Sprintf("roots: %v, depth: %d, flow: %s, fromBlock: %d, toBlock: %d, graphSizeLimit: %d, maxDegree: %d, neverExpand: %v, alwaysExpand: %v, edges: {%s}",
+		q.Roots, q.Depth, q.Flow, q.FromBlock, q.ToBlock, q.GraphSizeLimit, q.MaxDegree, q.NeverExpand, q.AlwaysExpand, q.Edges)
+}
+
+// withDefaults fills unset options
+func (q Query) withDefaults() Query {
+	if q.Flow == "" {
+		q.Flow = FLOW_ALL
+	}
+	if q.ToBlock == 0 {
+		q.ToBlock = MAX_BLOCK
+	}
+	if q.GraphSizeLimit == 0 {
+		q.GraphSizeLimit = GRAPH_LIMIT
+	}
+	if q.MaxDegree == 0 {
+		q.MaxDegree = TRAVERSE_MAX_DEGREE
+	}
+	if q.NeverExpand == nil {
+		q.NeverExpand = DEFAULT_NEVER_EXPAND
+	}
+	if q.AlwaysExpand == nil {
+		q.AlwaysExpand = DEFAULT_ALWAYS_EXPAND
+	}
+	return q
+}
+
+func (q Query) Validate() error {
+	if len(q.Roots) == 0 {
+		return fmt.Errorf("%w: no root address", ErrInvalidQuery)
+	}
+	if q.Depth < 0 {
+		return fmt.Errorf("%w: negative depth", ErrInvalidQuery)
+	}
+	if !slices.Contains([]string{FLOW_INPUT, FLOW_OUTPUT, FLOW_ALL}, q.Flow) {
+		return fmt.Errorf("%w: unknown flow %q", ErrInvalidQuery, q.Flow)
+	}
+	if q.FromBlock < 0 || q.FromBlock > q.ToBlock {
+		return fmt.Errorf("%w: block range %d..%d", ErrInvalidQuery, q.FromBlock, q.ToBlock)
+	}
+	if q.GraphSizeLimit < 0 || q.MaxDegree < 0 || q.MinEdgeUsd.IsNegative() {
+		return fmt.Errorf("%w: negative limit", ErrInvalidQuery)
+	}
+	if err := q.Edges.Validate(); err != nil {
+		return err
+	}
+	if q.MinTracedFraction < 0 || q.MinTracedFraction > 1 {
+		return fmt.Errorf("%w: traced fraction %f out of 0..1", ErrInvalidQuery, q.MinTracedFraction)
+	}
+	return nil
+}
+
+func (q Query) isRoot(addr string) bool {
+	return slices.Contains(q.Roots, addr)
+}
+
+// followsInput reports whether transactions sent to an address are followed
+func (q Query) followsInput() bool {
+	return q.Flow == FLOW_INPUT || q.Flow == FLOW_ALL
+}
+
+// followsOutput reports whether transactions sent from an address are followed
+func (q Query) followsOutput() bool {
+	return q.Flow == FLOW_OUTPUT || q.Flow == FLOW_ALL
+}
+
+// Traverser collects the graph around the roots of a query
+type Traverser interface {
+	Collect(ctx context.Context, query Query) (*Graph, error)
+}
+
+type DFS struct {
+	redis *redis.RedisClient
+}
+
+func (t *DFS) Collect(ctx context.Context, query Query) (*Graph, error) {
+	return CollectDFS(ctx, query, t.redis)
+}
+
+type BFS struct {
+	redis *redis.RedisClient
+}
+
+func (t *BFS) Collect(ctx context.Context, query Query) (*Graph, error) {
+	return CollectBFS(ctx, query, t.redis)
+}
+
+// New returns the traverser implementing algo, one of ALGO_*
+func New(algo string, redis *redis.RedisClient) (Traverser, error) {
+	switch algo {
+	case ALGO_DFS:
+		return &DFS{redis: redis}, nil
+	case ALGO_BFS:
+		return &BFS{redis: redis}, nil
+	case ALGO_MONEY:
+		return &Money{redis: redis}, nil
+	}
+	return nil, fmt.Errorf("%w: unknown algorithm %q", ErrInvalidQuery, algo)
+}
@@ -3,62 +3,35 @@ package traverser
 import (
 	"chain-traverser/internal/storage/redis"
 	"context"
+	"slices"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/rs/zerolog/log"
-	"github.com/shopspring/decimal"
 )
 
 const TRAVERSE_MAX_DEGREE = 300
 
-type CntRes struct {
-	key *string
-	cnt int64
-}
-
-type CntErr struct {
-	key *string
-	err error
-}
-
-func setCounters(addrs *map[string]Addr, storage *redis.RedisClient) error {
-	resChan := make(chan CntRes, len(*addrs))
-	errChan := make(chan CntErr, len(*addrs))
-
-	for key, _ := range *addrs {
-		go func(key *string) {
-			cnt, err := storage.GetAddressTxNumber(key)
-			if err != nil {
-				errChan <- CntErr{key: key, err: err}
-			} else {
-				resChan <- CntRes{key: key, cnt: cnt}
-			}
-		}(&key)
+func setCounters(ctx context.Context, addrs *map[string]Addr, query Query, fetcher *fetcher) error {
+	keys := make([]string, 0, len(*addrs))
+	for key := range *addrs {
+		keys = append(keys, key)
 	}
-
-	for key, _ := range *addrs {
-		select {
-		case errRes := <-errChan:
-			v := (*addrs)[*errRes.key]
-			v.NeedTraverse = false
-			(*addrs)[*errRes.key] = v
-			log.Err(errRes.err).Msgf("Cant set counter for %s", key)
-		case res := <-resChan:
-			v := (*addrs)[*res.key]
-			v.Cnt = res.cnt
-			if v.Cnt > TRAVERSE_MAX_DEGREE {
-				v.NeedTraverse = false
-			}
-			(*addrs)[*res.key] = v
-		}
+	inspected, err := inspect(ctx, keys, query, fetcher)
+	if err != nil {
+		log.Err(err).Msgf("Cant set counters of %d addresses", len(keys))
+		return err
+	}
+	for key, v := range *addrs {
+		v.Cnt = inspected[key].Cnt
+		v.Skipped = inspected[key].Skipped
+		v.NeedTraverse = v.NeedTraverse && v.Skipped == ""
+		(*addrs)[key] = v
 	}
-
 	return nil
 }
 
-func getBlocks(addrs *map[string]Addr, storage *redis.RedisClient) (*[]string, error) {
+func getBlocks(ctx context.Context, addrs *map[string]Addr, fetcher *fetcher) (*[]string, error) {
 	traverseAddrs := []string{}
 	for key, addr := range *addrs {
 		if addr.NeedTraverse {
@@ -66,208 +39,169 @@ func getBlocks(addrs *map[string]Addr, storage *redis.RedisClient) (*[]string, e
 		}
 	}
 
-	blocksSet := make(map[string]bool)
-
-	resChan := make(chan *[]string, len(*addrs))
-	errChan := make(chan error, len(*addrs))
-	for _, key := range traverseAddrs {
-		go func(key *string) {
-			bSlice, err := storage.GetAddressBlocks(key)
-			if err != nil {
-				errChan <- err
-			} else {
-				resChan <- bSlice
-			}
-		}(&key)
+	lists, err := fetcher.addressBlocks(ctx, traverseAddrs)
+	if err != nil {
+		log.Err(err).Msg("Cant get block list")
+		return nil, err
 	}
-	for key, _ := range traverseAddrs {
-		select {
-		case err := <-errChan:
-			log.Err(err).Msgf("Cant set block list for %d", key)
-		case res := <-resChan:
-			for _, block := range *res {
-				blocksSet[block] = true
-			}
+	blocksSet := make(map[string]bool)
+	for _, list := range lists {
+		for _, block := range list {
+			blocksSet[block] = true
 		}
 	}
 
 	blocks := []string{}
-	for key, _ := range blocksSet {
+	for key := range blocksSet {
 		blocks = append(blocks, key)
 	}
 	return &blocks, nil
 }
 
-func getTransactionsByBlockNumber(blockNumber string, addrs *map[string]Addr, storage *redis.RedisClient, fromBlock int, toBlock int, limiter *AtomicLimiter) (*[]Tx, error) {
-	blockNumberInt, err := strconv.Atoi(blockNumber)
-	if err != nil {
-		log.Err(err).Msgf("Failed to convert blockNumber to int: %s", blockNumber)
-		return nil, err
-	}
+// getTransactionsByBlockNumber picks txs of a parsed block that addrs send or receive
+// in the query flow direction
+func getTransactionsByBlockNumber(block []Tx, addrs *map[string]Addr, query Query) []Tx {
 	txs := []Tx{}
-
-	if fromBlock > blockNumberInt || toBlock < blockNumberInt {
-		return &txs, nil
-	}
-
-	block, err := storage.GetBlock(&blockNumber)
-	if err != nil {
-		log.Err(err).Msgf("getTransactionsByBlockNumber can't get block: %s", blockNumber)
-		return nil, err
-	}
-	for _, tx := range strings.Split(*block, "\n") {
-		if limiter.IsExceed() {
-			log.Warn().Msgf("limiter is exceed")
-			break
-		}
-		if tx == "" {
-			continue
-		}
-		vals := strings.Split(tx, ";")
-		from := vals[0]
-		to := vals[2]
-		txHash := vals[1]
-
-		fromAddr, existsFrom := (*addrs)[from]
-		toAddr, existsTo := (*addrs)[to]
+	for _, tx := range block {
+		fromAddr, existsFrom := (*addrs)[tx.From]
+		toAddr, existsTo := (*addrs)[tx.To]
 		// the following conditions exclude transactions that are not interesting for us
 		// we skip traversing over dex explicitly.
 		// hovewer, if one of the addresses had interaction with dex we got the dex address.
 		// We prefer to leave dex address with interaction transaction in graph without other dexs transactions.
 		// skip them
-		if !existsFrom && !existsTo {
-			continue
-		}
-		if existsFrom && existsTo && !fromAddr.NeedTraverse && !toAddr.NeedTraverse {
-			continue
-		}
-		if existsFrom && !fromAddr.NeedTraverse && !existsTo {
-			continue
+		if query.followsOutput() && existsFrom && fromAddr.NeedTraverse ||
+			query.followsInput() && existsTo && toAddr.NeedTraverse {
+			txs = append(txs, tx)
 		}
-		if existsTo && !toAddr.NeedTraverse && !existsFrom {
+	}
+	return txs
+}
+
+// inRange leaves out blocks outside fromBlock..toBlock and sorts the rest
+func inRange(blocks []string, fromBlock int, toBlock int) []string {
+	numbers := []int{}
+	for _, block := range blocks {
+		blockNumber, err := strconv.Atoi(block)
+		if err != nil {
+			log.Err(err).Msgf("Failed to convert blockNumber to int: %s", block)
 			continue
 		}
-
-		ethAmount, _ := decimal.NewFromString(vals[3])
-		ethAmount = ethAmount.Div(decimal.NewFromInt(1e18))
-		ethAmountUsdOnDay, _ := decimal.NewFromString(vals[4])
-
-		totalUsdFlow := ethAmountUsdOnDay
-		flowByCurrency := make(map[string]decimal.Decimal)
-		flowByCurrency["ETH"] = ethAmount
-		erc20 := vals[5]
-		if erc20 != "nil" {
-			erc20AmountUsdOnDay, _ := decimal.NewFromString(vals[7])
-			erc20Amount, _ := decimal.NewFromString(vals[6])
-			totalUsdFlow = ethAmountUsdOnDay.Add(erc20AmountUsdOnDay)
-			flowByCurrency[erc20] = erc20Amount
+		if blockNumber >= fromBlock && blockNumber <= toBlock {
+			numbers = append(numbers, blockNumber)
 		}
-
-		txs = append(txs, Tx{
-			From:           from,
-			To:             to,
-			TxHash:         txHash,
-			TotalUsdFlow:   totalUsdFlow,
-			FlowByCurrency: flowByCurrency,
-		})
-		limiter.Consume()
 	}
-
-	return &txs, nil
-}
-
-func getAddressTransactions(addrs map[string]Addr, redis *redis.RedisClient, ctx context.Context, depth int, fromBlock int, toBlock int, limiter *AtomicLimiter) (*[]Tx, error) {
-	log.Debug().Msgf("getAddressTransactions %d", depth)
-	if depth == 0 {
-		return nil, nil
+	slices.Sort(numbers)
+	filtered := make([]string, len(numbers))
+	for i, number := range numbers {
+		filtered[i] = strconv.Itoa(number)
 	}
+	return filtered
+}
 
-	err := setCounters(&addrs, redis)
+// getAddressTransactions returns txs of one BFS level in block order
+func getAddressTransactions(ctx context.Context, addrs map[string]Addr, query Query, fetcher *fetcher) ([]Tx, error) {
+	err := setCounters(ctx, &addrs, query, fetcher)
 	if err != nil {
 		return nil, err
 	}
 	log.Debug().Msgf("addr count: %d", len(addrs))
 
-	blocks, err := getBlocks(&addrs, redis)
+	blocks, err := getBlocks(ctx, &addrs, fetcher)
 	if err != nil {
-		log.Err(err).Msgf("getAddressTransactions failed on getting blocks | address: %s", addrs)
+		log.Err(err).Msgf("getAddressTransactions failed on getting blocks | addresses: %d", len(addrs))
 		return nil, err
 	}
-	log.Info().Msgf("depth: %d", depth)
 	log.Info().Msgf("block count: %d", len(*blocks))
 
-	var txs []Tx
-	resultChan := make(chan []Tx, len(*blocks))
-	errChan := make(chan error, len(*blocks))
-
-	for _, block := range *blocks {
-		go func(block string) {
-			local_txs, err := getTransactionsByBlockNumber(block, &addrs, redis, fromBlock, toBlock, limiter)
-			if err != nil {
-				errChan <- err
-			} else {
-				resultChan <- *local_txs
-			}
-		}(block)
-	}
-
-	for range *blocks {
-		select {
-		case err := <-errChan:
-			log.Err(err).Msgf("getTransactionsByBlockNumber | Error: %s", err)
-		case result := <-resultChan:
-			txs = append(txs, result...)
-		}
-	}
-	if depth == 1 {
-		return &txs, nil
+	blocksInRange := inRange(*blocks, query.FromBlock, query.ToBlock)
+	parsed, err := fetcher.blocks(ctx, blocksInRange)
+	if err != nil {
+		log.Err(err).Msgf("getAddressTransactions failed on getting %d blocks", len(blocksInRange))
+		return nil, err
 	}
 
-	// collect next bunch of addresses
-	// skip addresses from previous iteration
-	nextAddrs := make(map[string]Addr)
-	for _, tx := range txs {
-		_, exists := addrs[tx.From]
-		if !exists {
-			nextAddrs[tx.From] = Addr{Hash: tx.From, Cnt: 0, NeedTraverse: true}
-		}
-		_, exists = addrs[tx.To]
-		if !exists {
-			nextAddrs[tx.To] = Addr{Hash: tx.To, Cnt: 0, NeedTraverse: true}
-		}
+	var txs []Tx
+	for _, block := range blocksInRange {
+		txs = append(txs, getTransactionsByBlockNumber(parsed[block], &addrs, query)...)
 	}
-	txs2, err := getAddressTransactions(nextAddrs, redis, ctx, depth-1, fromBlock, toBlock, limiter)
-	if err != nil {
-		return nil, err
+	expanded := func(hash string) bool {
+		addr, exists := addrs[hash]
+		return exists && addr.NeedTraverse
 	}
-	txs = append(txs, *txs2...)
-	return &txs, nil
+	return filterEdges(ctx, txs, expanded, query, fetcher)
 }
 
-func CollectBFS(address string, depth int, fromBlock int, toBlock int, redis *redis.RedisClient) (*Graph, error) {
-	log.Info().Msgf("CollectBFS: %s, %d", address, depth)
-
-	ctx := context.Background()
+// CollectBFS walks the graph level by level from the query roots. Addresses of
+// each level closer than query.Depth to the roots are expanded together, so
+// every address is expanded at its smallest depth. The traversal stops once
+// query.GraphSizeLimit addresses or transactions are collected.
+func CollectBFS(ctx context.Context, query Query, redis *redis.RedisClient) (*Graph, error) {
+	return collectBFS(ctx, query, redis)
+}
 
+func collectBFS(ctx context.Context, query Query, store store) (*Graph, error) {
+	query = query.withDefaults()
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+	log.Info().Msgf("CollectBFS: %s", query)
 	start := time.Now()
 
-	addrs := make(map[string]Addr)
-	addrs[address] = Addr{Hash: address, Cnt: -1, NeedTraverse: true}
-	limiter := NewLimiter()
-	txs, err := getAddressTransactions(addrs, redis, ctx, depth, fromBlock, toBlock, limiter)
-
-	log.Info().Msgf("got all transactions in %s", time.Since(start))
+	graph := &Graph{
+		Addrs: &map[string]Addr{},
+		Txs:   &map[string]Tx{},
+	}
+	fetcher := newFetcher(store, query.Fetch)
 
-	if err != nil {
-		log.Err(err).Msgf("getAddressTransactions failed %s", address)
-		return nil, err
+	level := make(map[string]Addr)
+	for _, root := range query.Roots {
+		level[root] = Addr{Hash: root, NeedTraverse: true}
 	}
-	log.Info().Msgf("got %d transactions", len(*txs))
 
-	uTrsx := make(map[string]Tx)
-	for _, tx := range *txs {
-		uTrsx[tx.TxHash] = tx
+	for depth := 0; depth < query.Depth && len(level) > 0 && graph.Truncated == ""; depth++ {
+		if len(*graph.Addrs)+len(level) > query.GraphSizeLimit {
+			graph.Truncated = TRUNCATED_SIZE_LIMIT
+			break
+		}
+		txs, err := getAddressTransactions(ctx, level, query, fetcher)
+		if IsCancelled(err) {
+			log.Warn().Msgf("CollectBFS stopped at depth %d: %s", depth, err)
+			graph.Truncated = TruncatedBy(ctx)
+			break
+		}
+		if err != nil {
+			log.Err(err).Msgf("CollectBFS failed at depth %d", depth)
+			return nil, err
+		}
+		for hash, addr := range level {
+			addr.Depth = depth
+			(*graph.Addrs)[hash] = addr
+		}
+		log.Info().Msgf("depth %d: %d addresses, %d transactions", depth, len(level), len(txs))
+
+		// collect next bunch of addresses, skip the ones already expanded
+		next := make(map[string]Addr)
+		for _, tx := range txs {
+			if _, exists := (*graph.Txs)[tx.TxHash]; !exists {
+				if len(*graph.Txs) >= query.GraphSizeLimit {
+					graph.Truncated = TRUNCATED_SIZE_LIMIT
+					break
+				}
+				(*graph.Txs)[tx.TxHash] = tx
+			}
+			for _, hash := range []string{tx.From, tx.To} {
+				if _, exists := (*graph.Addrs)[hash]; !exists {
+					next[hash] = Addr{Hash: hash, NeedTraverse: true}
+				}
+			}
+		}
+		level = next
 	}
-	log.Info().Msgf("got %d unique transactions", len(uTrsx))
-	return &Graph{Addrs: &addrs, Txs: &uTrsx}, nil
+
+	markSeeds(graph, query)
+	graph.Stats = fetcher.Stats()
+	log.Info().Msgf("CollectBFS collected %d addresses, %d txs in %s, truncated: %q, fetch stats: %s",
+		len(*graph.Addrs), len(*graph.Txs), time.Since(start), graph.Truncated, graph.Stats)
+	return graph, nil
 }
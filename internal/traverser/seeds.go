@@ -0,0 +1,40 @@
+package traverser
+
+// markSeeds records, for every address of the graph, the roots reaching it and
+// their distance over the collected transactions in the query flow direction
+func markSeeds(graph *Graph, query Query) {
+	neighbours := make(map[string][]string)
+	for _, tx := range *graph.Txs {
+		if query.followsOutput() {
+			neighbours[tx.From] = append(neighbours[tx.From], tx.To)
+		}
+		if query.followsInput() {
+			neighbours[tx.To] = append(neighbours[tx.To], tx.From)
+		}
+	}
+
+	seeds := make(map[string]map[string]int)
+	for _, root := range query.Roots {
+		distance := map[string]int{root: 0}
+		level := []string{root}
+		for depth := 1; depth <= query.Depth && len(level) > 0; depth++ {
+			next := []string{}
+			for _, addr := range level {
+				for _, neighbour := range neighbours[addr] {
+					if _, seen := distance[neighbour]; !seen {
+						distance[neighbour] = depth
+						next = append(next, neighbour)
+					}
+				}
+			}
+			level = next
+		}
+		for addr, d := range distance {
+			if seeds[addr] == nil {
+				seeds[addr] = make(map[string]int)
+			}
+			seeds[addr][root] = d
+		}
+	}
+	graph.Seeds = seeds
+}